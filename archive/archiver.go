@@ -0,0 +1,252 @@
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+)
+
+// Record is a single archived event, one per line of an archive file
+type Record struct {
+	ID            uint              `json:"id"`
+	Channel       string            `json:"channel"`
+	ChannelUUID   string            `json:"channel_uuid"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Source        string            `json:"source"`
+	Level         models.EventLevel `json:"level"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Title         *string           `json:"title"`
+	Message       string            `json:"message"`
+	Attributes    models.Attributes `json:"attributes,omitempty"`
+	TraceID       *string           `json:"trace_id,omitempty"`
+	SpanID        *string           `json:"span_id,omitempty"`
+	CorrelationID *string           `json:"correlation_id,omitempty"`
+	DataMIMEType  *string           `json:"data_mime_type,omitempty"`
+	Data          *[]byte           `json:"data,omitempty"`
+}
+
+func NewRecord(channel *models.Channel, event *models.Event) Record {
+	r := Record{
+		ID:            event.ID,
+		Channel:       channel.Name,
+		ChannelUUID:   channel.UUID,
+		CreatedAt:     event.CreatedAt,
+		Source:        event.Source,
+		Level:         event.Level,
+		Timestamp:     event.Timestamp,
+		Title:         event.Title,
+		Message:       event.Message,
+		TraceID:       event.TraceID,
+		SpanID:        event.SpanID,
+		CorrelationID: event.CorrelationID,
+	}
+	for _, a := range event.Attributes {
+		if r.Attributes == nil {
+			r.Attributes = models.Attributes{}
+		}
+		r.Attributes[a.Key] = a.Value
+	}
+	if event.HasData {
+		r.DataMIMEType = &event.EventData.DataMIMEType
+		r.Data = &event.EventData.Data
+	}
+	return r
+}
+
+// Turn the record back into a new event for a channel
+func (r *Record) ToNewEvent(channelID uint) models.NewEvent {
+	ts := r.Timestamp.Format(time.RFC3339Nano)
+	return models.NewEvent{
+		ChannelID:     channelID,
+		Source:        r.Source,
+		Level:         r.Level,
+		Timestamp:     &ts,
+		Title:         r.Title,
+		Message:       r.Message,
+		Attributes:    r.Attributes,
+		TraceID:       r.TraceID,
+		SpanID:        r.SpanID,
+		CorrelationID: r.CorrelationID,
+		DataMIMEType:  r.DataMIMEType,
+		Data:          r.Data,
+	}
+}
+
+// Archiver writes events to gzip compressed NDJSON files, one directory per channel and one file per day
+type Archiver struct {
+	Directory string
+	location  *time.Location
+
+	// Files are appended to, so only one write at a time
+	mu sync.Mutex
+}
+
+func NewArchiver(config *configuration.Config) (*Archiver, error) {
+	if len(strings.TrimSpace(config.Archive.Directory)) == 0 {
+		return nil, fmt.Errorf("archive directory cannot be empty")
+	}
+	location, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return &Archiver{
+		Directory: config.Archive.Directory,
+		location:  location,
+	}, nil
+}
+
+// The file events from a channel on a day are archived to
+func (a *Archiver) Path(channel *models.Channel, day time.Time) string {
+	return filepath.Join(a.Directory, safeName(channel.Name), day.In(a.location).Format("2006-01-02")+".ndjson.gz")
+}
+
+// Staged archive data, written beside the files it belongs in but not part of them until it's committed
+type Staged struct {
+	archiver *Archiver
+	files    []stagedFile
+}
+
+type stagedFile struct {
+	path string
+	temp string
+}
+
+// Stage events from a channel for the archive, the staging files are synced before returning so the events are
+// safe to delete. Nothing is archived until Commit, so events which end up not being deleted (and are staged
+// again by the next purge) don't appear in the archive twice.
+func (a *Archiver) Stage(channel *models.Channel, events []models.Event) (staged *Staged, err error) {
+	// Group the events by the file they belong in...
+	var paths []string
+	byPath := map[string][]Record{}
+	for i := range events {
+		path := a.Path(channel, events[i].Timestamp)
+		if _, ok := byPath[path]; !ok {
+			paths = append(paths, path)
+		}
+		byPath[path] = append(byPath[path], NewRecord(channel, &events[i]))
+	}
+
+	staged = &Staged{archiver: a}
+	for _, path := range paths {
+		temp, err := stageRecords(path, byPath[path])
+		if err != nil {
+			staged.Discard()
+			return nil, fmt.Errorf("unable to archive to '%s': %w", path, err)
+		}
+		staged.files = append(staged.files, stagedFile{path: path, temp: temp})
+	}
+	return staged, nil
+}
+
+// Add the staged data to the end of the archive files...
+//
+// If a file can't be appended to, it and any after it are left staged (as "<file>.<random>.staged") and can be
+// restored like any other archive file.
+func (s *Staged) Commit() error {
+	s.archiver.mu.Lock()
+	defer s.archiver.mu.Unlock()
+
+	for len(s.files) > 0 {
+		file := s.files[0]
+		if err := appendFile(file.path, file.temp); err != nil {
+			return fmt.Errorf("unable to archive to '%s', the events are in '%s': %w", file.path, file.temp, err)
+		}
+		os.Remove(file.temp)
+		s.files = s.files[1:]
+	}
+	return nil
+}
+
+// Throw the staged data away
+func (s *Staged) Discard() {
+	for _, file := range s.files {
+		os.Remove(file.temp)
+	}
+	s.files = nil
+}
+
+// Write records to a new staging file next to the archive file, as a single gzip member
+func stageRecords(path string, records []Record) (temp string, err error) {
+	if err = os.MkdirAll(filepath.Dir(path), 0774); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.staged")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	zw := gzip.NewWriter(f)
+	encoder := json.NewEncoder(zw)
+	for i := range records {
+		if err = encoder.Encode(&records[i]); err != nil {
+			return "", err
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), f.Sync()
+}
+
+// Each commit adds a new gzip member to the end of the file, readers treat them as one stream. If the copy fails
+// part way the file is cut back to where it was, so it isn't left with half a member.
+func appendFile(path string, temp string) (err error) {
+	src, err := os.Open(temp)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(f, src); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(info.Size())
+	}
+	return err
+}
+
+// Channel names end up in paths, so keep them to a single directory
+func safeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', 0:
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "." || name == ".." || len(name) == 0 {
+		name = "_" + name
+	}
+	return name
+}
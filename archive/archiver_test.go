@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+)
+
+func testArchiver(t *testing.T) *Archiver {
+	var config configuration.Config
+	config.Timezone = "Etc/UTC"
+	config.Archive.Directory = t.TempDir()
+	a, err := NewArchiver(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// The IDs of the events archived to a file, in order
+func archivedIDs(t *testing.T, path string) []uint {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, record.ID)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func stagedFiles(t *testing.T, a *Archiver) []string {
+	files, err := filepath.Glob(filepath.Join(a.Directory, "*", "*.staged"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestStageCommit(t *testing.T) {
+	a := testArchiver(t)
+	channel := &models.Channel{Name: "channel", UUID: "uuid"}
+	day := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	event := func(id uint) models.Event {
+		e := models.Event{Message: "message", Timestamp: day}
+		e.ID = id
+		return e
+	}
+
+	for _, batch := range [][]models.Event{{event(1), event(2)}, {event(3)}} {
+		staged, err := a.Stage(channel, batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(a.Path(channel, day)); len(batch) == 2 && !os.IsNotExist(err) {
+			t.Error("expected nothing in the archive until the batch is committed")
+		}
+		if err := staged.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := archivedIDs(t, a.Path(channel, day))
+	if len(ids) != 3 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("expected events 1, 2 and 3 to be archived, got %v", ids)
+	}
+	if files := stagedFiles(t, a); len(files) > 0 {
+		t.Errorf("staging files left behind: %v", files)
+	}
+}
+
+func TestStageDiscard(t *testing.T) {
+	a := testArchiver(t)
+	channel := &models.Channel{Name: "channel", UUID: "uuid"}
+	day := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	staged, err := a.Stage(channel, []models.Event{{Message: "message", Timestamp: day}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged.Discard()
+	if _, err := os.Stat(a.Path(channel, day)); !os.IsNotExist(err) {
+		t.Error("expected a discarded batch not to be archived")
+	}
+	if files := stagedFiles(t, a); len(files) > 0 {
+		t.Errorf("staging files left behind: %v", files)
+	}
+}
@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+// Records longer than this (mostly event data) can't be restored
+const maxRecordSize = 64 * 1024 * 1024
+
+type RestoreReport struct {
+	Events  int64
+	Skipped int64
+}
+
+// Restore an archive file, into the given channel or (if nil) the channel each event was archived from...
+//
+// Events which are already in the channel are skipped, so a file can safely be restored more than once.
+// The file is restored in a single transaction, if anything fails nothing is restored.
+func Restore(tx *gorm.DB, path string, channel *models.Channel) (report RestoreReport, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return report, err
+	}
+	defer zr.Close()
+
+	channels := map[string]*models.Channel{}
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		scanner := bufio.NewScanner(zr)
+		scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			target := channel
+			if target == nil {
+				target = channels[record.ChannelUUID]
+			}
+			if target == nil {
+				c, err := recordChannel(tx, &record)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+				channels[record.ChannelUUID] = c
+				target = c
+			}
+
+			created, err := restoreRecord(tx, target, &record)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if created {
+				report.Events++
+			} else {
+				report.Skipped++
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return RestoreReport{}, err
+	}
+	return report, nil
+}
+
+// The channel a record was archived from, by UUID in case it has been renamed since
+func recordChannel(tx *gorm.DB, record *Record) (*models.Channel, error) {
+	if channel, err := models.ChannelByUUID(tx, record.ChannelUUID); err == nil {
+		return channel, nil
+	}
+	channel, err := models.ChannelByName(tx, record.Channel)
+	if err != nil {
+		return nil, fmt.Errorf("channel '%s' not found", record.Channel)
+	}
+	return channel, nil
+}
+
+func restoreRecord(tx *gorm.DB, channel *models.Channel, record *Record) (bool, error) {
+	n := record.ToNewEvent(channel.ID)
+	event, err := n.ToEvent()
+	if err != nil {
+		return false, err
+	}
+	if !event.Level.IsValid() {
+		return false, fmt.Errorf("%s is not a valid EventLevel", event.Level)
+	}
+	// Keep the type the data was archived with rather than detecting it again...
+	if event.HasData && n.DataMIMEType != nil {
+		event.EventData.DataMIMEType = *n.DataMIMEType
+	}
+
+	var count int64
+	result := tx.Model(&models.Event{}).Where("channel_id = ? AND source = ? AND level = ? AND timestamp = ? AND message = ?", event.ChannelID, event.Source, event.Level, event.Timestamp, event.Message).Count(&count)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	result = tx.Create(&event)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/kaigoh/loggo/models"
+)
+
+// HasPermission implements the @hasPermission directive...
+//
+// With a channel argument name the permission is checked against that channel, with global it is checked
+// against every channel, otherwise the principal only needs the permission somewhere and the resolver is
+// expected to filter what it returns.
+func HasPermission(ctx context.Context, obj interface{}, next graphql.Resolver, permission models.Permission, channel *string, global *bool) (interface{}, error) {
+	principal := ForContext(ctx)
+	if principal == nil {
+		return nil, ErrUnauthenticated
+	}
+
+	switch {
+	case global != nil && *global:
+		if !principal.Allows(nil, permission) {
+			return nil, ErrForbidden
+		}
+	case channel != nil:
+		channelID, err := channelArgument(ctx, *channel)
+		if err != nil {
+			return nil, err
+		}
+		if !principal.Allows(channelID, permission) {
+			return nil, ErrForbidden
+		}
+	default:
+		ids, all := principal.Channels(permission)
+		if !all && len(ids) == 0 {
+			return nil, ErrForbidden
+		}
+	}
+
+	return next(ctx)
+}
+
+// Find a channel ID in the field arguments, a missing or null argument means every channel
+func channelArgument(ctx context.Context, name string) (*uint, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil {
+		return nil, fmt.Errorf("no field context for @hasPermission")
+	}
+	switch v := fc.Args[name].(type) {
+	case uint:
+		return &v, nil
+	case *uint:
+		return v, nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("argument '%s' is not a channel ID", name)
+}
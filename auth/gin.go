@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaigoh/loggo/models"
+)
+
+const TokenHeader = "Loggo-Token"
+
+// Pull a token from either the Authorization or Loggo-Token headers
+func TokenFromHeader(h http.Header) string {
+	if token := h.Get(TokenHeader); len(token) > 0 {
+		return token
+	}
+	return BearerToken(h.Get("Authorization"))
+}
+
+// The token from an Authorization value, the scheme isn't case sensitive
+func BearerToken(authorization string) string {
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+		return authorization[7:]
+	}
+	return ""
+}
+
+// Attach the principal to the request, anonymous requests are left for later checks to reject
+func (g *Guard) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := TokenFromHeader(c.Request.Header)
+		if len(token) == 0 && g.Enabled() {
+			c.Next()
+			return
+		}
+		principal, err := g.Authenticate(token)
+		if err != nil {
+			c.AbortWithError(http.StatusUnauthorized, err)
+			return
+		}
+		c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), principal))
+		c.Next()
+	}
+}
+
+// Reject requests which don't have the permission on the channel returned by lookup
+func (g *Guard) RequireChannel(permission models.Permission, lookup func(c *gin.Context) (*models.Channel, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channel, err := lookup(c)
+		if errors.Is(err, models.ErrChannelNotFound) {
+			c.AbortWithError(http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		checked(c, Check(c.Request.Context(), &channel.ID, permission))
+	}
+}
+
+// Reject requests which don't have the permission for every channel, such as those for debugging endpoints
+func (g *Guard) Require(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checked(c, Check(c.Request.Context(), nil, permission))
+	}
+}
+
+func checked(c *gin.Context, err error) {
+	switch err {
+	case nil:
+		c.Next()
+	case ErrUnauthenticated:
+		c.AbortWithError(http.StatusUnauthorized, err)
+	default:
+		c.AbortWithError(http.StatusForbidden, err)
+	}
+}
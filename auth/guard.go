@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+type ctxKey string
+
+const (
+	principalKey = ctxKey("principal")
+)
+
+// How often the last used timestamp of a key is written back to the database
+const lastUsedResolution = time.Minute
+
+var ErrUnauthenticated = errors.New("authentication required")
+var ErrForbidden = errors.New("permission denied")
+
+// Principal is whoever is making a request, a nil key means they are unrestricted
+// (authentication is disabled, or they used the admin token from the configuration)
+type Principal struct {
+	Key *models.APIKey
+}
+
+func (p *Principal) Unrestricted() bool {
+	return p.Key == nil
+}
+
+func (p *Principal) Allows(channelID *uint, permission models.Permission) bool {
+	if p.Unrestricted() {
+		return true
+	}
+	return p.Key.Allows(channelID, permission)
+}
+
+func (p *Principal) Channels(permission models.Permission) (ids []uint, all bool) {
+	if p.Unrestricted() {
+		return nil, true
+	}
+	return p.Key.Channels(permission)
+}
+
+// Can the principal see and revoke the key? They need to be an admin of everything it grants access to, and
+// a key which grants nothing (its channels have all been deleted) is only for admins of every channel
+func (p *Principal) CanManage(key *models.APIKey) bool {
+	if len(key.Scopes) == 0 {
+		return p.Allows(nil, models.PermissionAdmin)
+	}
+	for _, s := range key.Scopes {
+		if !p.Allows(s.ChannelID, models.PermissionAdmin) {
+			return false
+		}
+	}
+	return true
+}
+
+// Guard turns tokens into principals
+type Guard struct {
+	config *configuration.Config
+	tx     *gorm.DB
+}
+
+func NewGuard(config *configuration.Config, tx *gorm.DB) *Guard {
+	return &Guard{
+		config: config,
+		tx:     tx,
+	}
+}
+
+func (g *Guard) Enabled() bool {
+	return g.config.Auth.Enabled
+}
+
+func (g *Guard) AdminToken() string {
+	return g.config.Auth.AdminToken
+}
+
+func (g *Guard) Authenticate(token string) (*Principal, error) {
+	if !g.Enabled() {
+		return &Principal{}, nil
+	}
+
+	token = strings.TrimSpace(token)
+	if len(token) == 0 {
+		return nil, ErrUnauthenticated
+	}
+
+	admin := g.config.Auth.AdminToken
+	if len(admin) > 0 && subtle.ConstantTimeCompare([]byte(admin), []byte(token)) == 1 {
+		return &Principal{}, nil
+	}
+
+	key, err := models.APIKeyByToken(g.tx, token)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > lastUsedResolution {
+		key.LastUsedAt = &now
+		if result := g.tx.Model(key).UpdateColumn("last_used_at", now); result.Error != nil {
+			log.Println("Unable to update last used time for API key '"+key.Name+"'", result.Error)
+		}
+	}
+
+	return &Principal{Key: key}, nil
+}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// The principal making the request, or nil if they haven't authenticated
+func ForContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey).(*Principal)
+	return principal
+}
+
+// Check the principal in the context has the permission on a channel (or every channel if nil)
+func Check(ctx context.Context, channelID *uint, permission models.Permission) error {
+	principal := ForContext(ctx)
+	if principal == nil {
+		return ErrUnauthenticated
+	}
+	if !principal.Allows(channelID, permission) {
+		return ErrForbidden
+	}
+	return nil
+}
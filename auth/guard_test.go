@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/kaigoh/loggo/models"
+)
+
+func TestCanManage(t *testing.T) {
+	one, two := uint(1), uint(2)
+	globalAdmin := &Principal{Key: &models.APIKey{Scopes: []models.APIKeyScope{{Permission: models.PermissionAdmin}}}}
+	channelAdmin := &Principal{Key: &models.APIKey{Scopes: []models.APIKeyScope{{ChannelID: &one, Permission: models.PermissionAdmin}}}}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		key       *models.APIKey
+		want      bool
+	}{
+		{"admin token", &Principal{}, &models.APIKey{}, true},
+		{"global admin, no scopes", globalAdmin, &models.APIKey{}, true},
+		{"channel admin, no scopes", channelAdmin, &models.APIKey{}, false},
+		{"channel admin, own channel", channelAdmin, &models.APIKey{Scopes: []models.APIKeyScope{{ChannelID: &one, Permission: models.PermissionRead}}}, true},
+		{"channel admin, other channel", channelAdmin, &models.APIKey{Scopes: []models.APIKeyScope{{ChannelID: &two, Permission: models.PermissionRead}}}, false},
+		{"channel admin, every channel", channelAdmin, &models.APIKey{Scopes: []models.APIKeyScope{{Permission: models.PermissionRead}}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.principal.CanManage(tt.key); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
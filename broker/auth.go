@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"strings"
+
+	"github.com/kaigoh/loggo/auth"
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+// AuthController checks MQTT clients against Loggo's API keys...
+//
+// Clients either connect with the key name as the username and its token as the password, or with a token
+// (including the admin token) as the username and no password.
+type AuthController struct {
+	guard *auth.Guard
+	tx    *gorm.DB
+}
+
+func NewAuthController(guard *auth.Guard, tx *gorm.DB) *AuthController {
+	return &AuthController{
+		guard: guard,
+		tx:    tx,
+	}
+}
+
+func (a *AuthController) Authenticate(user, password []byte) bool {
+	if !a.guard.Enabled() {
+		return true
+	}
+	if len(user) == 0 {
+		return false
+	}
+	if len(password) == 0 {
+		_, err := a.guard.Authenticate(string(user))
+		return err == nil
+	}
+	principal, err := a.guard.Authenticate(string(password))
+	if err != nil || principal.Unrestricted() {
+		return false
+	}
+	return principal.Key.Name == string(user)
+}
+
+func (a *AuthController) ACL(user []byte, topic string, write bool) bool {
+	if !a.guard.Enabled() {
+		return true
+	}
+	principal, err := a.principal(string(user))
+	if err != nil {
+		return false
+	}
+	if principal.Unrestricted() {
+		return true
+	}
+
+	permission := models.PermissionRead
+	if write {
+		permission = models.PermissionWrite
+	}
+
+	// Anything outside of the channels (like $SYS) is only for unrestricted clients...
+	name, _, err := ParseTopic(topic)
+	if err != nil {
+		return false
+	}
+
+	// Wildcards could match any channel...
+	if strings.ContainsAny(name, "#+") {
+		return principal.Allows(nil, permission)
+	}
+
+	channel, err := models.ChannelByMQTTTopic(a.tx, name)
+	if err != nil {
+		return false
+	}
+	return principal.Allows(&channel.ID, permission)
+}
+
+// The client has already authenticated, so the username alone is enough to find their key again
+func (a *AuthController) principal(user string) (*auth.Principal, error) {
+	admin := a.guard.AdminToken()
+	if strings.HasPrefix(user, models.APIKeyTokenPrefix) || (len(admin) > 0 && user == admin) {
+		return a.guard.Authenticate(user)
+	}
+	key, err := models.APIKeyByName(a.tx, user)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Principal{Key: key}, nil
+}
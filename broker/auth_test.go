@@ -0,0 +1,68 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/kaigoh/loggo/auth"
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// An auth controller with a channel named web and keys for reading every channel (global) or just web (scoped)
+func newTestAuthController(t *testing.T) *AuthController {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Channel{}, &models.APIKey{}, &models.APIKeyScope{}); err != nil {
+		t.Fatal(err)
+	}
+	channel := &models.Channel{UUID: "web", Name: "web"}
+	if err := db.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	keys := []*models.NewAPIKey{
+		{Name: "global", Scopes: []*models.NewAPIKeyScope{{Permissions: []models.Permission{models.PermissionRead}}}},
+		{Name: "scoped", Scopes: []*models.NewAPIKeyScope{{ChannelID: &channel.ID, Permissions: []models.Permission{models.PermissionRead}}}},
+	}
+	for _, n := range keys {
+		key, _, err := n.ToAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&key).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &configuration.Config{}
+	config.Auth.Enabled = true
+	return NewAuthController(auth.NewGuard(config, db), db)
+}
+
+func TestACLWildcards(t *testing.T) {
+	a := newTestAuthController(t)
+	tests := []struct {
+		user  string
+		topic string
+		write bool
+		want  bool
+	}{
+		{"global", "/channel/#", false, true},
+		{"global", "/channel/+", false, true},
+		{"global", "/channel/web", false, true},
+		{"global", "/channel/#", true, false},
+		{"scoped", "/channel/#", false, false},
+		{"scoped", "/channel/+", false, false},
+		{"scoped", "/channel/web", false, true},
+		{"scoped", "/channel/web", true, false},
+		{"global", "$SYS/#", false, false},
+	}
+	for _, tt := range tests {
+		if got := a.ACL([]byte(tt.user), tt.topic, tt.write); got != tt.want {
+			t.Errorf("%s on %s (write %v): got %v, want %v", tt.user, tt.topic, tt.write, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/kaigoh/loggo/configuration"
+	mqtt "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/listeners"
+	"github.com/mochi-co/mqtt/server/listeners/auth"
+)
+
+// Add every configured listener to the server...
+//
+// Websockets get their own listener when they have a port, otherwise they share the HTTP server and the
+// returned listener needs mounting on it at the configured path.
+func AddListeners(server *mqtt.Server, config *configuration.Config, ac auth.Controller) (shared *HTTPWebsocket, err error) {
+	tcp := listeners.NewTCP("t1", ":"+strconv.Itoa(int(config.Server.MQTTPort)))
+	err = server.AddListener(tcp, &listeners.Config{
+		Auth: ac,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := config.Server.MQTTTLS
+	if tlsConfig.Enabled {
+		tc, err := TLSConfig(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT: %w", err)
+		}
+		listener := listeners.NewTCP("tls1", ":"+strconv.Itoa(int(tlsConfig.Port)))
+		err = server.AddListener(listener, &listeners.Config{
+			Auth:      ac,
+			TLSConfig: tc,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	wsConfig := config.Server.MQTTWebsocket
+	if wsConfig.Enabled {
+		if wsConfig.Port == 0 {
+			shared = NewHTTPWebsocket("ws1")
+			err = server.AddListener(shared, &listeners.Config{
+				Auth: ac,
+			})
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			lc := &listeners.Config{
+				Auth: ac,
+			}
+			if wsConfig.TLS {
+				lc.TLSConfig, err = TLSConfig(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile)
+				if err != nil {
+					return nil, fmt.Errorf("MQTT: %w", err)
+				}
+			}
+			listener := listeners.NewWebsocket("ws1", ":"+strconv.Itoa(int(wsConfig.Port)))
+			err = server.AddListener(listener, lc)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return shared, nil
+}
+
+// Load a certificate and key, requiring client certificates signed by the CA when one is given
+func TLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, fmt.Errorf("TLS needs both a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load TLS certificate: %w", err)
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(clientCAFile) > 0 {
+		pem, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA '%s'", clientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
@@ -0,0 +1,35 @@
+package broker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Channel topics look like /channel/<topic>, optionally followed by URL style query parameters...
+//
+// The query is split off by hand rather than parsing the topic as a URL, which would take the # of a
+// topic filter (/channel/#) as the start of a fragment.
+func ParseTopic(topic string) (name string, params url.Values, err error) {
+	path, query := topic, ""
+	if i := strings.IndexByte(topic, '?'); i >= 0 {
+		path, query = topic[:i], topic[i+1:]
+	}
+	path, err = url.PathUnescape(path)
+	if err != nil {
+		return "", nil, err
+	}
+	// Like url.URL.Query, malformed pairs are skipped rather than failing the whole topic...
+	params, _ = url.ParseQuery(query)
+	p := strings.Split(path, "/")
+	var s []string
+	for _, e := range p {
+		if len(e) > 0 {
+			s = append(s, e)
+		}
+	}
+	if len(s) < 2 || s[0] != "channel" {
+		return "", nil, fmt.Errorf("topic '%s' is not a valid channel", topic)
+	}
+	return s[1], params, nil
+}
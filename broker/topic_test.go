@@ -0,0 +1,40 @@
+package broker
+
+import "testing"
+
+func TestParseTopic(t *testing.T) {
+	tests := []struct {
+		topic  string
+		name   string
+		source string
+	}{
+		{"/channel/web", "web", ""},
+		{"channel/web", "web", ""},
+		{"/channel/web?source=api&level=error", "web", "api"},
+		{"/channel/my%20app?source=a%2Fb", "my app", "a/b"},
+		{"/channel/#", "#", ""},
+		{"/channel/+", "+", ""},
+		{"/channel/+/events", "+", ""},
+	}
+	for _, tt := range tests {
+		name, params, err := ParseTopic(tt.topic)
+		if err != nil {
+			t.Errorf("%s: %v", tt.topic, err)
+			continue
+		}
+		if name != tt.name {
+			t.Errorf("%s: got channel %q, want %q", tt.topic, name, tt.name)
+		}
+		if got := params.Get("source"); got != tt.source {
+			t.Errorf("%s: got source %q, want %q", tt.topic, got, tt.source)
+		}
+	}
+}
+
+func TestParseTopicInvalid(t *testing.T) {
+	for _, topic := range []string{"", "/", "/channel", "/channel/", "/other/web", "$SYS/broker/clients", "#"} {
+		if _, _, err := ParseTopic(topic); err == nil {
+			t.Errorf("%s: expected an error", topic)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package broker
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/mochi-co/mqtt/server/listeners"
+	"github.com/mochi-co/mqtt/server/listeners/auth"
+	"github.com/mochi-co/mqtt/server/system"
+)
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{"mqtt"},
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// HTTPWebsocket is an MQTT listener which is mounted on an existing HTTP server rather than owning a port
+type HTTPWebsocket struct {
+	sync.RWMutex
+	id        string
+	config    *listeners.Config
+	establish listeners.EstablishFunc
+}
+
+func NewHTTPWebsocket(id string) *HTTPWebsocket {
+	return &HTTPWebsocket{
+		id: id,
+		config: &listeners.Config{
+			Auth: new(auth.Allow),
+		},
+	}
+}
+
+func (l *HTTPWebsocket) SetConfig(config *listeners.Config) {
+	l.Lock()
+	defer l.Unlock()
+	if config != nil {
+		l.config = config
+		// Same as the built in listeners, no auth controller is probably a mistake...
+		if l.config.Auth == nil {
+			l.config.Auth = new(auth.Disallow)
+		}
+	}
+}
+
+func (l *HTTPWebsocket) Listen(s *system.Info) error {
+	return nil
+}
+
+func (l *HTTPWebsocket) Serve(establish listeners.EstablishFunc) {
+	l.Lock()
+	l.establish = establish
+	l.Unlock()
+}
+
+func (l *HTTPWebsocket) ID() string {
+	return l.id
+}
+
+func (l *HTTPWebsocket) Close(closeClients listeners.CloseFunc) {
+	l.Lock()
+	l.establish = nil
+	l.Unlock()
+	closeClients(l.id)
+}
+
+func (l *HTTPWebsocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.RLock()
+	establish := l.establish
+	ac := l.config.Auth
+	l.RUnlock()
+	if establish == nil {
+		http.Error(w, "MQTT broker is not running", http.StatusServiceUnavailable)
+		return
+	}
+
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	establish(l.id, &wsConn{Conn: c.UnderlyingConn(), c: c}, ac)
+}
+
+// wsConn turns binary websocket messages back into a byte stream
+type wsConn struct {
+	net.Conn
+	c      *websocket.Conn
+	reader io.Reader
+}
+
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for {
+		if ws.reader == nil {
+			op, r, err := ws.c.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if op != websocket.BinaryMessage {
+				return 0, listeners.ErrInvalidMessage
+			}
+			ws.reader = r
+		}
+		n, err := ws.reader.Read(p)
+		if err == io.EOF {
+			ws.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (ws *wsConn) Write(p []byte) (int, error) {
+	err := ws.c.WriteMessage(websocket.BinaryMessage, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -15,20 +15,115 @@ type Config struct {
 	defaultsLoaded  bool
 	Timezone        string `default:"Etc/UTC" yaml:"timezone" envconfig:"TZ"`
 	DefaultEntryTTL string `default:"672h" yaml:"default_entry_ttl" envconfig:"DEFAULT_ENTRY_TTL"`
-	Database        struct {
+	// Per level defaults for channels without a TTL of their own, blank levels fall back to default_entry_ttl
+	LevelTTL struct {
+		Debug   string `default:"" yaml:"debug" envconfig:"DEBUG_TTL"`
+		Info    string `default:"" yaml:"info" envconfig:"INFO_TTL"`
+		Warning string `default:"" yaml:"warning" envconfig:"WARNING_TTL"`
+		Error   string `default:"" yaml:"error" envconfig:"ERROR_TTL"`
+		Fatal   string `default:"" yaml:"fatal" envconfig:"FATAL_TTL"`
+	} `yaml:"level_ttl"`
+	Database struct {
 		Type                string `default:"sqlite" yaml:"type" envconfig:"DATABASE_TYPE"`
 		DSN                 string `default:"" yaml:"dsn" envconfig:"DATABASE_DSN"`
 		SQLiteDataDirectory string `default:"data" yaml:"sqlite_directory" envconfig:"DATABASE_PATH"`
+		ConnectRetries      uint   `default:"10" yaml:"connect_retries" envconfig:"DATABASE_CONNECT_RETRIES"`
+		ConnectBackoff      string `default:"3s" yaml:"connect_backoff" envconfig:"DATABASE_CONNECT_BACKOFF"`
 	} `yaml:"database"`
 	Server struct {
-		URL      string `default:"http://127.0.0.1:8080" yaml:"base_url" envconfig:"BASE_URL"`
-		HTTPPort uint   `default:"8080" yaml:"http_port" envconfig:"HTTP_PORT"`
-		MQTTPort uint   `default:"1883" yaml:"mqtt_port" envconfig:"MQTT_PORT"`
+		URL             string `default:"http://127.0.0.1:8080" yaml:"base_url" envconfig:"BASE_URL"`
+		HTTPPort        uint   `default:"8080" yaml:"http_port" envconfig:"HTTP_PORT"`
+		ShutdownTimeout string `default:"30s" yaml:"shutdown_timeout" envconfig:"SHUTDOWN_TIMEOUT"`
+		MQTTPort        uint   `default:"1883" yaml:"mqtt_port" envconfig:"MQTT_PORT"`
+		MQTTTLS         struct {
+			Enabled      bool   `default:"false" yaml:"enabled" envconfig:"MQTT_TLS_ENABLED"`
+			Port         uint   `default:"8883" yaml:"port" envconfig:"MQTT_TLS_PORT"`
+			CertFile     string `default:"" yaml:"cert_file" envconfig:"MQTT_TLS_CERT_FILE"`
+			KeyFile      string `default:"" yaml:"key_file" envconfig:"MQTT_TLS_KEY_FILE"`
+			ClientCAFile string `default:"" yaml:"client_ca_file" envconfig:"MQTT_TLS_CLIENT_CA_FILE"`
+		} `yaml:"mqtt_tls"`
+		MQTTWebsocket struct {
+			Enabled bool   `default:"false" yaml:"enabled" envconfig:"MQTT_WS_ENABLED"`
+			Port    uint   `default:"0" yaml:"port" envconfig:"MQTT_WS_PORT"`
+			Path    string `default:"/mqtt" yaml:"path" envconfig:"MQTT_WS_PATH"`
+			TLS     bool   `default:"false" yaml:"tls" envconfig:"MQTT_WS_TLS"`
+		} `yaml:"mqtt_websocket"`
+		// How long /readyz reports shutting down before anything stops, so load balancers stop sending us work
+		ReadinessGracePeriod string `default:"5s" yaml:"readiness_grace_period" envconfig:"READINESS_GRACE_PERIOD"`
 	} `yaml:"server"`
+	Syslog struct {
+		Enabled bool `default:"false" yaml:"enabled" envconfig:"SYSLOG_ENABLED"`
+		// Either port can be 0 to turn that listener off
+		UDPPort        uint `default:"5514" yaml:"udp_port" envconfig:"SYSLOG_UDP_PORT"`
+		TCPPort        uint `default:"5514" yaml:"tcp_port" envconfig:"SYSLOG_TCP_PORT"`
+		MaxMessageSize uint `default:"65536" yaml:"max_message_size" envconfig:"SYSLOG_MAX_MESSAGE_SIZE"`
+		TLS            struct {
+			Enabled      bool   `default:"false" yaml:"enabled" envconfig:"SYSLOG_TLS_ENABLED"`
+			Port         uint   `default:"6514" yaml:"port" envconfig:"SYSLOG_TLS_PORT"`
+			CertFile     string `default:"" yaml:"cert_file" envconfig:"SYSLOG_TLS_CERT_FILE"`
+			KeyFile      string `default:"" yaml:"key_file" envconfig:"SYSLOG_TLS_KEY_FILE"`
+			ClientCAFile string `default:"" yaml:"client_ca_file" envconfig:"SYSLOG_TLS_CLIENT_CA_FILE"`
+		} `yaml:"tls"`
+		// Messages which don't match a route go here, or are dropped if it is blank
+		DefaultChannel string        `default:"" yaml:"default_channel" envconfig:"SYSLOG_DEFAULT_CHANNEL"`
+		Routes         []SyslogRoute `yaml:"routes" ignored:"true"`
+	} `yaml:"syslog"`
+	OTLP struct {
+		// Where logs go when neither the loggo.channel resource attribute nor the Loggo-Channel header say
+		DefaultChannel string `default:"" yaml:"default_channel" envconfig:"OTLP_DEFAULT_CHANNEL"`
+	} `yaml:"otlp"`
+	Loki struct {
+		// The stream label naming the channel, streams without it go to the default channel
+		ChannelLabel   string `default:"channel" yaml:"channel_label" envconfig:"LOKI_CHANNEL_LABEL"`
+		SourceLabel    string `default:"job" yaml:"source_label" envconfig:"LOKI_SOURCE_LABEL"`
+		DefaultChannel string `default:"" yaml:"default_channel" envconfig:"LOKI_DEFAULT_CHANNEL"`
+	} `yaml:"loki"`
 	Ntfy struct {
 		Enabled  bool   `default:"false" yaml:"enabled" envconfig:"NTFY_ENABLED"`
 		Endpoint string `default:"" yaml:"endpoint" envconfig:"NTFY_ENDPOINT"`
+		Timeout  string `default:"10s" yaml:"timeout" envconfig:"NTFY_TIMEOUT"`
+		Retries  uint   `default:"3" yaml:"retries" envconfig:"NTFY_RETRIES"`
+		Backoff  string `default:"1s" yaml:"backoff" envconfig:"NTFY_BACKOFF"`
+		// Notifications waiting for a worker, once full new ones are dropped
+		QueueSize uint `default:"1000" yaml:"queue_size" envconfig:"NTFY_QUEUE_SIZE"`
+		Workers   uint `default:"4" yaml:"workers" envconfig:"NTFY_WORKERS"`
 	} `yaml:"ntfy"`
+	Retention struct {
+		Interval     string `default:"1h" yaml:"interval" envconfig:"RETENTION_INTERVAL"`
+		BatchSize    uint   `default:"1000" yaml:"batch_size" envconfig:"RETENTION_BATCH_SIZE"`
+		MaxBatches   uint   `default:"0" yaml:"max_batches" envconfig:"RETENTION_MAX_BATCHES"`
+		RunOnStartup bool   `default:"true" yaml:"run_on_startup" envconfig:"RETENTION_RUN_ON_STARTUP"`
+	} `yaml:"retention"`
+	Ingest struct {
+		QueueSize uint `default:"10000" yaml:"queue_size" envconfig:"INGEST_QUEUE_SIZE"`
+		Writers   uint `default:"4" yaml:"writers" envconfig:"INGEST_WRITERS"`
+		BatchSize uint `default:"500" yaml:"batch_size" envconfig:"INGEST_BATCH_SIZE"`
+	} `yaml:"ingest"`
+	Spool struct {
+		Enabled        bool   `default:"false" yaml:"enabled" envconfig:"SPOOL_ENABLED"`
+		Directory      string `default:"spool" yaml:"directory" envconfig:"SPOOL_DIRECTORY"`
+		SegmentSize    uint   `default:"16777216" yaml:"segment_size" envconfig:"SPOOL_SEGMENT_SIZE"`
+		ReplayInterval string `default:"5s" yaml:"replay_interval" envconfig:"SPOOL_REPLAY_INTERVAL"`
+	} `yaml:"spool"`
+	Archive struct {
+		Enabled   bool   `default:"false" yaml:"enabled" envconfig:"ARCHIVE_ENABLED"`
+		Directory string `default:"archive" yaml:"directory" envconfig:"ARCHIVE_DIRECTORY"`
+	} `yaml:"archive"`
+	Auth struct {
+		Enabled    bool   `default:"false" yaml:"enabled" envconfig:"AUTH_ENABLED"`
+		AdminToken string `default:"" yaml:"admin_token" envconfig:"AUTH_ADMIN_TOKEN"`
+	} `yaml:"auth"`
+}
+
+// A syslog message goes to the channel of the first route it matches...
+//
+// Blank fields match anything, hostname and app_name can be glob patterns (web-*) and facility is a name
+// (local0, auth...) or number.
+type SyslogRoute struct {
+	Channel  string `yaml:"channel"`
+	Facility string `yaml:"facility"`
+	Hostname string `yaml:"hostname"`
+	AppName  string `yaml:"app_name"`
 }
 
 const ConfigFile string = "config.yml"
@@ -92,3 +187,48 @@ func (c *Config) LoadConfigFromFile() (bool, error) {
 func (c *Config) GetDefaultEntryTTL() (time.Duration, error) {
 	return time.ParseDuration(c.DefaultEntryTTL)
 }
+
+// The default TTL for events of a level, or an empty string if there isn't one
+func (c *Config) GetLevelTTL(level string) string {
+	switch level {
+	case "debug":
+		return c.LevelTTL.Debug
+	case "info":
+		return c.LevelTTL.Info
+	case "warning":
+		return c.LevelTTL.Warning
+	case "error":
+		return c.LevelTTL.Error
+	case "fatal":
+		return c.LevelTTL.Fatal
+	}
+	return ""
+}
+
+func (c *Config) GetDatabaseConnectBackoff() (time.Duration, error) {
+	return time.ParseDuration(c.Database.ConnectBackoff)
+}
+
+func (c *Config) GetSpoolReplayInterval() (time.Duration, error) {
+	return time.ParseDuration(c.Spool.ReplayInterval)
+}
+
+func (c *Config) GetShutdownTimeout() (time.Duration, error) {
+	return time.ParseDuration(c.Server.ShutdownTimeout)
+}
+
+func (c *Config) GetReadinessGracePeriod() (time.Duration, error) {
+	return time.ParseDuration(c.Server.ReadinessGracePeriod)
+}
+
+func (c *Config) GetNtfyTimeout() (time.Duration, error) {
+	return time.ParseDuration(c.Ntfy.Timeout)
+}
+
+func (c *Config) GetNtfyBackoff() (time.Duration, error) {
+	return time.ParseDuration(c.Ntfy.Backoff)
+}
+
+func (c *Config) GetRetentionInterval() (time.Duration, error) {
+	return time.ParseDuration(c.Retention.Interval)
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+const defaultConnectionSize = 100
+const maxConnectionSize = 1000
+
+// Relay style connection arguments, first/after page forwards (older) and last/before page backwards (newer)
+type ConnectionArgs struct {
+	First  *uint
+	After  *string
+	Last   *uint
+	Before *string
+}
+
+// Page through events newest first using keyset pagination on (timestamp, id), so pages stay stable
+// while new events arrive and deep pages cost the same as the first
+func PaginateEvents(tx *gorm.DB, args ConnectionArgs) (*models.EventConnection, error) {
+	if args.First != nil && args.Last != nil {
+		return nil, fmt.Errorf("first and last cannot be used together")
+	}
+
+	conn := &models.EventConnection{
+		Edges:    []*models.EventEdge{},
+		PageInfo: &models.PageInfo{},
+	}
+	result := tx.Session(&gorm.Session{}).Model(&models.Event{}).Count(&conn.TotalCount)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	page := tx.Session(&gorm.Session{})
+	if args.After != nil {
+		c, err := models.DecodeEventCursor(*args.After)
+		if err != nil {
+			return nil, err
+		}
+		page = page.Where("(timestamp < ? OR (timestamp = ? AND id < ?))", c.Timestamp, c.Timestamp, c.ID)
+	}
+	if args.Before != nil {
+		c, err := models.DecodeEventCursor(*args.Before)
+		if err != nil {
+			return nil, err
+		}
+		page = page.Where("(timestamp > ? OR (timestamp = ? AND id > ?))", c.Timestamp, c.Timestamp, c.ID)
+	}
+
+	backwards := args.Last != nil
+	size := defaultConnectionSize
+	if args.First != nil {
+		size = int(*args.First)
+	} else if args.Last != nil {
+		size = int(*args.Last)
+	}
+	if size > maxConnectionSize {
+		size = maxConnectionSize
+	}
+	if backwards {
+		page = page.Order("timestamp ASC").Order("id ASC")
+	} else {
+		page = page.Order("timestamp DESC").Order("id DESC")
+	}
+
+	// Fetch one extra row to find out if there is another page...
+	var events []*models.Event
+	result = page.Limit(size + 1).Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	more := len(events) > size
+	if more {
+		events = events[:size]
+	}
+	if backwards {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+		conn.PageInfo.HasPreviousPage = more
+		conn.PageInfo.HasNextPage = args.Before != nil
+	} else {
+		conn.PageInfo.HasNextPage = more
+		conn.PageInfo.HasPreviousPage = args.After != nil
+	}
+
+	for _, e := range events {
+		conn.Edges = append(conn.Edges, &models.EventEdge{
+			Cursor: models.CursorForEvent(e).Encode(),
+			Node:   e,
+		})
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+
+	return conn, nil
+}
@@ -1,10 +1,13 @@
 package database
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/kaigoh/loggo/configuration"
@@ -21,39 +24,60 @@ var DB *gorm.DB
 var config *configuration.Config
 var tzLocation time.Location
 
-func SetTimezone() {
-	loc, tze := time.LoadLocation(config.Timezone)
-	if tze != nil {
-		log.Println(config.Timezone)
-		panic("Unable to load requested timezone!")
+// Set once migrations have run
+var migrated int32
+
+func SetTimezone() error {
+	loc, err := time.LoadLocation(config.Timezone)
+	if err != nil {
+		return fmt.Errorf("unable to load timezone '%s': %w", config.Timezone, err)
 	}
 	tzLocation = *loc
+	return nil
+}
+
+// The configured timezone, used when grouping events by day
+func Location() *time.Location {
+	return &tzLocation
 }
 
-// Connect to a database
-func Connect(c *configuration.Config) *gorm.DB {
+// Connect to a database, giving up once the retries run out or the context is done
+func Connect(ctx context.Context, c *configuration.Config) (*gorm.DB, error) {
 
 	config = c
 
 	// Set the timezone...
-	SetTimezone()
+	if err := SetTimezone(); err != nil {
+		return nil, err
+	}
 
-	// If the database has been overridden, try and connect...
-	switch strings.ToLower(config.Database.Type) {
-	case "mysql":
-		DB = connectMysql()
-	case "postgres":
-		DB = connectPostgres()
-	case "sqlserver":
-		DB = connectSQLServer()
-	default:
-		DB = connectSQLite()
+	// Try and connect, the database may still be starting (or restarting) so give it a few chances...
+	backoff, err := config.GetDatabaseConnectBackoff()
+	if err != nil {
+		return nil, fmt.Errorf("invalid database connect backoff: %w", err)
+	}
+	for attempt := uint(0); ; attempt++ {
+		DB, err = open()
+		if err == nil {
+			break
+		}
+		if attempt >= config.Database.ConnectRetries {
+			return nil, fmt.Errorf("%w, please check your configuration and restart the server", err)
+		}
+		log.Printf("%s, retrying in %s (%d of %d)\n", err, backoff, attempt+1, config.Database.ConnectRetries)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up connecting to the database: %w", ctx.Err())
+		}
 	}
 
 	// Run migrations
-	Migrate(DB)
+	if err := Migrate(DB); err != nil {
+		return nil, fmt.Errorf("unable to migrate the database: %w", err)
+	}
 
-	return DB
+	return DB, nil
 
 }
 
@@ -69,63 +93,96 @@ func getLogger() logger.Interface {
 	)
 }
 
-func connectMysql() *gorm.DB {
+// If the database has been overridden, try and connect to it...
+func open() (*gorm.DB, error) {
+	switch strings.ToLower(config.Database.Type) {
+	case "mysql":
+		return connectMysql()
+	case "postgres":
+		return connectPostgres()
+	case "sqlserver":
+		return connectSQLServer()
+	}
+	return connectSQLite()
+}
+
+func connectMysql() (*gorm.DB, error) {
 	db, err := gorm.Open(mysql.Open(config.Database.DSN), &gorm.Config{
 		Logger:      getLogger(),
 		PrepareStmt: true,
 	})
 	if err != nil {
-		panic("MySQL Error: Unable to connect to database, please check your configuration and restart the server")
+		return nil, fmt.Errorf("MySQL Error: Unable to connect to database (%w)", err)
 	}
-	return db
+	return db, nil
 }
 
-func connectPostgres() *gorm.DB {
+func connectPostgres() (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.Open(config.Database.DSN), &gorm.Config{
 		Logger:      getLogger(),
 		PrepareStmt: true,
 	})
 	if err != nil {
-		panic("Postgres Error: Unable to connect to database, please check your configuration and restart the server")
+		return nil, fmt.Errorf("Postgres Error: Unable to connect to database (%w)", err)
 	}
-	return db
+	return db, nil
 }
 
-func connectSQLServer() *gorm.DB {
+func connectSQLServer() (*gorm.DB, error) {
 	db, err := gorm.Open(sqlserver.Open(config.Database.DSN), &gorm.Config{
 		Logger:      getLogger(),
 		PrepareStmt: true,
 	})
 	if err != nil {
-		panic("SQL Server Error: Unable to connect to database, please check your configuration and restart the server")
+		return nil, fmt.Errorf("SQL Server Error: Unable to connect to database (%w)", err)
 	}
-	return db
+	return db, nil
 }
 
-func connectSQLite() *gorm.DB {
+func connectSQLite() (*gorm.DB, error) {
 
 	path := config.Database.SQLiteDataDirectory
 	// Create the database path if needed...
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		os.Mkdir(path, 0774)
 	}
-	filename := filepath.Join(path, "loggo.db")
+	// Foreign keys are off by default in SQLite, and we need them for cascading deletes...
+	filename := filepath.Join(path, "loggo.db") + "?_foreign_keys=on"
 
 	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{
 		Logger:      getLogger(),
 		PrepareStmt: true,
 	})
 	if err != nil {
-		panic("SQLite Error: Unable to connect to database, please check your configuration and restart the server")
+		return nil, fmt.Errorf("SQLite Error: Unable to connect to database (%w)", err)
 	}
-	return db
+	return db, nil
 }
 
 // Migrate all models
-func Migrate(db *gorm.DB) {
-	if err := db.AutoMigrate(&models.Channel{}, &models.Event{}, &models.EventData{}); err != nil {
-		panic(err.Error())
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&models.Channel{}, &models.Event{}, &models.EventData{}, &models.EventAttribute{}, &models.APIKey{}, &models.APIKeyScope{}); err != nil {
+		return err
 	}
+
+	// Event data stored before sizes were recorded...
+	length := "LENGTH(data)"
+	switch db.Dialector.Name() {
+	case "postgres":
+		length = "OCTET_LENGTH(data)"
+	case "sqlserver":
+		length = "DATALENGTH(data)"
+	}
+	if err := db.Model(&models.EventData{}).Where("size = 0 AND data IS NOT NULL").Update("size", gorm.Expr(length)).Error; err != nil {
+		return err
+	}
+	atomic.StoreInt32(&migrated, 1)
+	return nil
+}
+
+// Whether the schema has been migrated
+func Migrated() bool {
+	return atomic.LoadInt32(&migrated) == 1
 }
 
 func Paginate(page int, pageSize int) func(db *gorm.DB) *gorm.DB {
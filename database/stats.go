@@ -0,0 +1,126 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+// Limits to keep a single request from building an enormous response
+const maxHistogramBuckets = 10000
+const maxStatsSources = 100
+
+// Count a channels events in a time range, by level and by source (busiest sources first)
+func EventStats(tx *gorm.DB, channelID uint, from time.Time, to time.Time) (*models.EventStats, error) {
+	stats := &models.EventStats{
+		ByLevel:  []*models.LevelCount{},
+		BySource: []*models.SourceCount{},
+	}
+	inRange := func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&models.Event{}).Where("channel_id = ? AND timestamp >= ? AND timestamp < ?", channelID, from, to)
+	}
+
+	var levels []*models.LevelCount
+	result := tx.Scopes(inRange).Select("level, COUNT(*) AS count").Group("level").Scan(&levels)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Level.Severity() < levels[j].Level.Severity() })
+	for _, l := range levels {
+		stats.Total += l.Count
+	}
+	stats.ByLevel = append(stats.ByLevel, levels...)
+
+	result = tx.Scopes(inRange).Select("source, COUNT(*) AS count").Group("source").Order("count DESC").Limit(maxStatsSources).Scan(&stats.BySource)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return stats, nil
+}
+
+// Count a channels events in buckets of time, in the configured timezone...
+//
+// The database counts events in fixed slots of UTC time (a minute for minute buckets, otherwise a quarter
+// of an hour) and the slots are added up into buckets here, so however busy the channel is only a row per
+// slot and level comes back. Timezone offsets and DST changes fall on quarter hours, so every slot lies
+// within one (DST aware) bucket whichever database it came from.
+func EventHistogram(tx *gorm.DB, channelID uint, from time.Time, to time.Time, bucket models.TimeBucket, levels []models.EventLevel) ([]*models.HistogramBucket, error) {
+	loc := Location()
+
+	// Lay out every bucket first, so quiet periods come back as zeroes...
+	var buckets []*models.HistogramBucket
+	index := map[int64]*models.HistogramBucket{}
+	for start := bucket.Truncate(from, loc); start.Before(to); start = bucket.Next(start) {
+		if len(buckets) >= maxHistogramBuckets {
+			return nil, fmt.Errorf("time range needs more than %d buckets, use a larger bucket", maxHistogramBuckets)
+		}
+		b := &models.HistogramBucket{Start: start, Levels: []*models.LevelCount{}}
+		buckets = append(buckets, b)
+		index[start.Unix()] = b
+	}
+
+	width := 900
+	if bucket == models.TimeBucketMinute {
+		width = 60
+	}
+	slot := slotExpression(tx, width)
+	q := tx.Model(&models.Event{}).Select(slot+" AS slot, level, COUNT(*) AS count").
+		Where("channel_id = ? AND timestamp >= ? AND timestamp < ?", channelID, from, to)
+	if len(levels) > 0 {
+		q = q.Where("level IN ?", levels)
+	}
+	var slots []struct {
+		Slot  int64
+		Level models.EventLevel
+		Count int64
+	}
+	result := q.Group(slot + ", level").Scan(&slots)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	counts := map[int64]map[models.EventLevel]int64{}
+	for _, s := range slots {
+		key := bucket.Truncate(time.Unix(s.Slot, 0).In(loc), loc).Unix()
+		b, ok := index[key]
+		if !ok {
+			continue
+		}
+		b.Count += s.Count
+		if counts[key] == nil {
+			counts[key] = map[models.EventLevel]int64{}
+		}
+		counts[key][s.Level] += s.Count
+	}
+
+	for key, byLevel := range counts {
+		b := index[key]
+		for _, l := range models.AllEventLevel {
+			if c, ok := byLevel[l]; ok {
+				b.Levels = append(b.Levels, &models.LevelCount{Level: l, Count: c})
+			}
+		}
+	}
+
+	return buckets, nil
+}
+
+// SQL for the start of the slot (in Unix seconds) an event's timestamp falls in
+func slotExpression(tx *gorm.DB, width int) string {
+	w := strconv.Itoa(width)
+	switch tx.Dialector.Name() {
+	case "postgres":
+		return "(FLOOR(EXTRACT(EPOCH FROM timestamp) / " + w + ") * " + w + ")::bigint"
+	case "mysql":
+		// Timestamps are written in the connection's timezone (UTC unless the DSN says otherwise)...
+		return "(FLOOR(TIMESTAMPDIFF(SECOND, '1970-01-01 00:00:00', timestamp) / " + w + ") * " + w + ")"
+	case "sqlserver":
+		return "((DATEDIFF_BIG(SECOND, '1970-01-01', timestamp) / " + w + ") * " + w + ")"
+	}
+	return "((CAST(strftime('%s', timestamp) AS INTEGER) / " + w + ") * " + w + ")"
+}
@@ -0,0 +1,21 @@
+package database
+
+import (
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+// What a channel currently holds, as counted against its quota
+func ChannelUsage(tx *gorm.DB, channelID uint) (*models.ChannelUsage, error) {
+	usage := &models.ChannelUsage{}
+	result := tx.Model(&models.Event{}).Where("channel_id = ?", channelID).Count(&usage.Events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	events := tx.Model(&models.Event{}).Select("id").Where("channel_id = ?", channelID)
+	result = tx.Model(&models.EventData{}).Select("COALESCE(SUM(size), 0)").Where("event_id IN (?)", events).Scan(&usage.DataBytes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return usage, nil
+}
@@ -0,0 +1,196 @@
+package elastic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kaigoh/loggo/models"
+)
+
+// Date suffixes added to index names by logstash_format and the like (logs-2024.01.31)
+var dateSuffix = regexp.MustCompile(`[-_.]\d{4}[-.]\d{2}[-.]\d{2}$`)
+
+// Fields commonly used by shippers, in the order they are tried...
+var (
+	messageFields   = []string{"message", "log", "msg", "MESSAGE"}
+	timestampFields = []string{"@timestamp", "timestamp", "time", "date"}
+	levelFields     = []string{"level", "log.level", "severity", "levelname", "lvl"}
+	sourceFields    = []string{"host.name", "host", "hostname", "service.name", "service"}
+)
+
+// Updates and deletes can't be done, events are never changed
+var ErrUnsupportedAction = errors.New("events can't be changed")
+
+// Item is one action from a bulk request. Only index and create actions (which carry a document) are
+// supported, others are reported back as failed.
+type Item struct {
+	Action string
+	Index  string
+	ID     string
+	Event  *models.RoutedNewEvent
+}
+
+// The outcome of an item, as Elasticsearch reports it
+type ItemResult struct {
+	Index  string     `json:"_index"`
+	ID     string     `json:"_id,omitempty"`
+	Status int        `json:"status"`
+	Result string     `json:"result,omitempty"`
+	Error  *ItemError `json:"error,omitempty"`
+}
+
+type ItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Response to a bulk request, with one result per item keyed by its action
+type Response struct {
+	Took   int64                    `json:"took"`
+	Errors bool                     `json:"errors"`
+	Items  []map[string]*ItemResult `json:"items"`
+}
+
+// The channel for an index, which is its name without any date suffix
+func IndexChannel(index string) string {
+	return dateSuffix.ReplaceAllString(index, "")
+}
+
+// Decode a bulk request, action lines each followed by a document (except for deletes)...
+//
+// The index comes from the action, or defaultIndex when the request was made to /<index>/_bulk.
+func Decode(body []byte, defaultIndex string) ([]*Item, error) {
+	var items []*Item
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	next := func() ([]byte, bool) {
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				return line, true
+			}
+		}
+		return nil, false
+	}
+
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+		if err := checkBatchSize(len(items) + 1); err != nil {
+			return nil, err
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(line, &action); err != nil || len(action) != 1 {
+			return nil, fmt.Errorf("invalid bulk action %d", len(items)+1)
+		}
+		item := &Item{}
+		for name, meta := range action {
+			item.Action, item.Index, item.ID = name, meta.Index, meta.ID
+		}
+		if len(item.Index) == 0 {
+			item.Index = defaultIndex
+		}
+		items = append(items, item)
+
+		switch item.Action {
+		case "index", "create":
+		case "update":
+			next()
+			fallthrough
+		case "delete":
+			item.Event = &models.RoutedNewEvent{Err: fmt.Errorf("%s is not supported, %w", item.Action, ErrUnsupportedAction)}
+			continue
+		default:
+			return nil, fmt.Errorf("unknown bulk action '%s'", item.Action)
+		}
+
+		doc, ok := next()
+		if !ok {
+			return nil, fmt.Errorf("bulk %s action is missing its document", item.Action)
+		}
+		item.Event = &models.RoutedNewEvent{Channel: IndexChannel(item.Index)}
+		item.Event.Event, item.Event.Err = newEvent(doc, item.Index)
+		if item.Event.Err == nil && len(item.Index) == 0 {
+			item.Event.Err = fmt.Errorf("no index, set _index or push to /<index>/_bulk")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func checkBatchSize(n int) error {
+	if n > models.MaxNewEventBatch {
+		return fmt.Errorf("a batch cannot have more than %d events", models.MaxNewEventBatch)
+	}
+	return nil
+}
+
+// A document becomes an event with the whole document kept as its data
+func newEvent(doc []byte, index string) (*models.NewEvent, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+
+	n := &models.NewEvent{Level: models.EventLevelInfo}
+	n.Message = first(fields, messageFields)
+	n.Source = first(fields, sourceFields)
+	if len(n.Source) == 0 {
+		n.Source = IndexChannel(index)
+	}
+	if level, ok := models.ParseEventLevel(first(fields, levelFields)); ok {
+		n.Level = level
+	}
+	if ts := first(fields, timestampFields); len(ts) > 0 {
+		if t, err := parseTimestamp(ts); err == nil {
+			formatted := t.UTC().Format(time.RFC3339Nano)
+			n.Timestamp = &formatted
+		}
+	}
+	data := append([]byte{}, doc...)
+	n.Data = &data
+	return n, n.Validate()
+}
+
+// The first of the fields which is a non-empty string, dotted names are looked up as nested objects too
+func first(fields map[string]interface{}, names []string) string {
+	for _, name := range names {
+		if s, ok := lookup(fields, name).(string); ok && len(strings.TrimSpace(s)) > 0 {
+			return s
+		}
+	}
+	return ""
+}
+
+func lookup(fields map[string]interface{}, name string) interface{} {
+	if v, ok := fields[name]; ok {
+		return v
+	}
+	if dot := strings.IndexByte(name, '.'); dot > 0 {
+		if nested, ok := fields[name[:dot]].(map[string]interface{}); ok {
+			return lookup(nested, name[dot+1:])
+		}
+	}
+	return nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700", "2006-01-02 15:04:05.999999999"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp '%s'", s)
+}
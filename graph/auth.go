@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"context"
+
+	"github.com/kaigoh/loggo/auth"
+	"github.com/kaigoh/loggo/models"
+)
+
+// Narrow the requested channels down to the ones the caller can read...
+//
+// Nil means no restriction (every channel), asking for a channel that can't be read is an error rather
+// than being silently dropped.
+func readableChannels(ctx context.Context, requested []uint) ([]uint, error) {
+	principal := auth.ForContext(ctx)
+	if principal == nil {
+		return nil, auth.ErrUnauthenticated
+	}
+	for _, id := range requested {
+		id := id
+		if !principal.Allows(&id, models.PermissionRead) {
+			return nil, auth.ErrForbidden
+		}
+	}
+	if requested != nil {
+		return requested, nil
+	}
+	ids, all := principal.Channels(models.PermissionRead)
+	if all {
+		return nil, nil
+	}
+	if ids == nil {
+		ids = []uint{}
+	}
+	return ids, nil
+}
@@ -4,6 +4,9 @@ package graph
 
 import (
 	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/pubsub"
+	"github.com/kaigoh/loggo/retention"
+	"github.com/kaigoh/loggo/search"
 	"gorm.io/gorm"
 )
 
@@ -12,6 +15,31 @@ import (
 // It serves as dependency injection for your app, add any dependencies you require here.
 
 type Resolver struct {
-	DB     *gorm.DB
-	Config *configuration.Config
+	DB       *gorm.DB
+	Config   *configuration.Config
+	PubSub   *pubsub.Hub
+	Searcher search.Engine
+	Purger   *retention.Purger
+}
+
+// Events returned for a trace when no limit is given, and the most that can be asked for
+const defaultTraceLimit = 1000
+const maxTraceLimit = 10000
+
+func traceLimit(limit *uint) int {
+	if limit == nil || *limit < 1 {
+		return defaultTraceLimit
+	}
+	if *limit > maxTraceLimit {
+		return maxTraceLimit
+	}
+	return int(*limit)
+}
+
+// Search applies its own default and maximum, so a missing limit is left to it
+func searchLimit(limit *uint) int {
+	if limit == nil {
+		return 0
+	}
+	return int(*limit)
 }
@@ -5,20 +5,179 @@ package graph
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/kaigoh/loggo/auth"
 	"github.com/kaigoh/loggo/database"
 	"github.com/kaigoh/loggo/graph/generated"
 	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/pubsub"
+	"github.com/kaigoh/loggo/search"
 	"github.com/kaigoh/loggo/storage"
+	"gorm.io/gorm"
 )
 
+func (r *aPIKeyScopeResolver) Channel(ctx context.Context, obj *models.APIKeyScope) (*models.Channel, error) {
+	if obj.ChannelID == nil {
+		return nil, nil
+	}
+	return storage.GetChannel(ctx, *obj.ChannelID)
+}
+
+func (r *channelResolver) LevelTtls(ctx context.Context, obj *models.Channel) ([]*models.LevelTTL, error) {
+	return obj.LevelTTLs(r.Config)
+}
+
+func (r *channelResolver) Usage(ctx context.Context, obj *models.Channel) (*models.ChannelUsage, error) {
+	return database.ChannelUsage(r.DB, obj.ID)
+}
+
 func (r *eventResolver) Data(ctx context.Context, obj *models.Event) (*string, error) {
 	return obj.GetDataURL(r.Config, r.DB)
 }
 
+func (r *eventResolver) Attributes(ctx context.Context, obj *models.Event) ([]*models.EventAttribute, error) {
+	// New events already have theirs, the rest are loaded together for every event in the response...
+	attrs := obj.Attributes
+	if attrs == nil {
+		var err error
+		attrs, err = storage.GetEventAttributes(ctx, obj.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	out := make([]*models.EventAttribute, len(attrs))
+	for i := range attrs {
+		out[i] = &attrs[i]
+	}
+	return out, nil
+}
+
+func (r *mutationResolver) CreateChannel(ctx context.Context, input models.NewChannel) (*models.Channel, error) {
+	channel, err := input.ToChannel()
+	if err != nil {
+		return nil, err
+	}
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := channel.Validate(tx); err != nil {
+			return err
+		}
+		// GORM swaps zero values for the column default on create, so make sure disabled toggles stick...
+		toggles := map[string]interface{}{
+			"mqtt_enabled": channel.MQTT,
+			"ntfy_enabled": channel.Ntfy,
+		}
+		if err := tx.Create(&channel).Error; err != nil {
+			return err
+		}
+		return tx.Model(&channel).Updates(toggles).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	channel.AfterFind(r.DB)
+	return &channel, nil
+}
+
+func (r *mutationResolver) UpdateChannel(ctx context.Context, id uint, input models.UpdateChannel) (*models.Channel, error) {
+	var channel models.Channel
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		// Read the raw row so that default topics aren't written back...
+		result := tx.Session(&gorm.Session{SkipHooks: true}).Where("id = ?", id).Find(&channel)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("channel not found")
+		}
+		changes := input.Apply(&channel)
+		if len(changes) == 0 {
+			return nil
+		}
+		if err := channel.Validate(tx); err != nil {
+			return err
+		}
+		return tx.Model(&channel).Updates(changes).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	channel.AfterFind(r.DB)
+	return &channel, nil
+}
+
+func (r *mutationResolver) DeleteChannel(ctx context.Context, id uint) (bool, error) {
+	// Events (and their data) are removed by the cascading foreign keys...
+	result := r.DB.Delete(&models.Channel{}, id)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, fmt.Errorf("channel not found")
+	}
+	return true, nil
+}
+
+func (r *mutationResolver) IssueAPIKey(ctx context.Context, input models.NewAPIKey) (*models.IssuedAPIKey, error) {
+	// Keys can only be issued for channels the caller is an admin of...
+	principal := auth.ForContext(ctx)
+	for _, s := range input.Scopes {
+		if !principal.Allows(s.ChannelID, models.PermissionAdmin) {
+			return nil, auth.ErrForbidden
+		}
+	}
+	key, token, err := input.ToAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	var existing int64
+	if err := r.DB.Model(&models.APIKey{}).Where("name = ?", key.Name).Count(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing > 0 {
+		return nil, fmt.Errorf("an API key named '%s' already exists", key.Name)
+	}
+	result := r.DB.Create(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &models.IssuedAPIKey{Token: token, Key: &key}, nil
+}
+
+func (r *mutationResolver) RevokeAPIKey(ctx context.Context, id uint) (bool, error) {
+	var key models.APIKey
+	result := r.DB.Preload("Scopes").Where("id = ?", id).Find(&key)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, fmt.Errorf("API key not found")
+	}
+	if !auth.ForContext(ctx).CanManage(&key) {
+		return false, auth.ErrForbidden
+	}
+	if key.RevokedAt != nil {
+		return true, nil
+	}
+	result = r.DB.Model(&key).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return true, nil
+}
+
+func (r *mutationResolver) PurgeExpiredEvents(ctx context.Context, channelID *uint) (*models.PurgeReport, error) {
+	return r.Purger.Purge(channelID)
+}
+
 func (r *queryResolver) GetChannels(ctx context.Context) ([]*models.Channel, error) {
 	var channels []*models.Channel
-	result := r.DB.Order("name ASC").Find(&channels)
+	tx := r.DB.Order("name ASC")
+	if ids, all := auth.ForContext(ctx).Channels(models.PermissionRead); !all {
+		tx = tx.Where("id IN ?", ids)
+	}
+	result := tx.Find(&channels)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -30,7 +189,14 @@ func (r *queryResolver) GetChannel(ctx context.Context, id uint) (*models.Channe
 }
 
 func (r *queryResolver) GetEvent(ctx context.Context, id uint) (*models.Event, error) {
-	return storage.GetEvent(ctx, id)
+	event, err := storage.GetEvent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.Check(ctx, &event.ChannelID, models.PermissionRead); err != nil {
+		return nil, err
+	}
+	return event, nil
 }
 
 func (r *queryResolver) GetChannelEvents(ctx context.Context, channelID uint, page *uint, pageSize *uint) ([]*models.Event, error) {
@@ -51,11 +217,131 @@ func (r *queryResolver) GetSourceEvents(ctx context.Context, channelID uint, sou
 	return events, nil
 }
 
+func (r *queryResolver) Events(ctx context.Context, filter *models.EventFilter, page *uint, pageSize *uint) ([]*models.Event, error) {
+	if filter == nil {
+		filter = &models.EventFilter{}
+	}
+	channels, err := readableChannels(ctx, filter.ChannelIDs)
+	if err != nil {
+		return nil, err
+	}
+	filter.ChannelIDs = channels
+
+	var events []*models.Event
+	result := r.DB.Scopes(filter.Scope, database.Paginate(int(*page), int(*pageSize))).Order("timestamp DESC").Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return events, nil
+}
+
+func (r *queryResolver) GetChannelEventsConnection(ctx context.Context, channelID uint, first *uint, after *string, last *uint, before *string) (*models.EventConnection, error) {
+	tx := r.DB.Where("channel_id = ?", channelID)
+	return database.PaginateEvents(tx, database.ConnectionArgs{First: first, After: after, Last: last, Before: before})
+}
+
+func (r *queryResolver) GetSourceEventsConnection(ctx context.Context, channelID uint, source string, first *uint, after *string, last *uint, before *string) (*models.EventConnection, error) {
+	tx := r.DB.Where("channel_id = ? AND source = ?", channelID, source)
+	return database.PaginateEvents(tx, database.ConnectionArgs{First: first, After: after, Last: last, Before: before})
+}
+
+func (r *queryResolver) EventsConnection(ctx context.Context, filter *models.EventFilter, first *uint, after *string, last *uint, before *string) (*models.EventConnection, error) {
+	if filter == nil {
+		filter = &models.EventFilter{}
+	}
+	channels, err := readableChannels(ctx, filter.ChannelIDs)
+	if err != nil {
+		return nil, err
+	}
+	filter.ChannelIDs = channels
+
+	tx := r.DB.Scopes(filter.Scope)
+	return database.PaginateEvents(tx, database.ConnectionArgs{First: first, After: after, Last: last, Before: before})
+}
+
+func (r *queryResolver) EventsByTrace(ctx context.Context, traceID string, limit *uint) ([]*models.Event, error) {
+	channels, err := readableChannels(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	filter := &models.EventFilter{ChannelIDs: channels, TraceID: &traceID}
+
+	var events []*models.Event
+	result := r.DB.Scopes(filter.Scope).Order("timestamp ASC").Order("id ASC").Limit(traceLimit(limit)).Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return events, nil
+}
+
+func (r *queryResolver) Search(ctx context.Context, query string, channelIds []uint, levels []models.EventLevel, from *time.Time, to *time.Time, limit *uint) ([]*models.SearchResult, error) {
+	channels, err := readableChannels(ctx, channelIds)
+	if err != nil {
+		return nil, err
+	}
+	return r.Searcher.Search(r.DB, search.Params{
+		Query:      query,
+		ChannelIDs: channels,
+		Levels:     levels,
+		From:       from,
+		To:         to,
+		Limit:      searchLimit(limit),
+	})
+}
+
+func (r *queryResolver) EventStats(ctx context.Context, channelID uint, from time.Time, to time.Time) (*models.EventStats, error) {
+	return database.EventStats(r.DB, channelID, from, to)
+}
+
+func (r *queryResolver) EventHistogram(ctx context.Context, channelID uint, from time.Time, to time.Time, bucket *models.TimeBucket, levels []models.EventLevel) ([]*models.HistogramBucket, error) {
+	return database.EventHistogram(r.DB, channelID, from, to, *bucket, levels)
+}
+
+func (r *queryResolver) GetAPIKeys(ctx context.Context) ([]*models.APIKey, error) {
+	var keys []*models.APIKey
+	result := r.DB.Preload("Scopes").Order("name ASC").Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	principal := auth.ForContext(ctx)
+	var visible []*models.APIKey
+	for _, k := range keys {
+		if principal.CanManage(k) {
+			visible = append(visible, k)
+		}
+	}
+	return visible, nil
+}
+
+func (r *subscriptionResolver) EventAdded(ctx context.Context, channelID *uint, minLevel *models.EventLevel, source *string) (<-chan *models.Event, error) {
+	return r.PubSub.Subscribe(ctx, pubsub.Filter{
+		ChannelID: channelID,
+		MinLevel:  minLevel,
+		Source:    source,
+	}), nil
+}
+
+// APIKeyScope returns generated.APIKeyScopeResolver implementation.
+func (r *Resolver) APIKeyScope() generated.APIKeyScopeResolver { return &aPIKeyScopeResolver{r} }
+
+// Channel returns generated.ChannelResolver implementation.
+func (r *Resolver) Channel() generated.ChannelResolver { return &channelResolver{r} }
+
 // Event returns generated.EventResolver implementation.
 func (r *Resolver) Event() generated.EventResolver { return &eventResolver{r} }
 
+// Mutation returns generated.MutationResolver implementation.
+func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
+
 // Query returns generated.QueryResolver implementation.
 func (r *Resolver) Query() generated.QueryResolver { return &queryResolver{r} }
 
+// Subscription returns generated.SubscriptionResolver implementation.
+func (r *Resolver) Subscription() generated.SubscriptionResolver { return &subscriptionResolver{r} }
+
+type aPIKeyScopeResolver struct{ *Resolver }
+type channelResolver struct{ *Resolver }
 type eventResolver struct{ *Resolver }
+type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
@@ -0,0 +1,143 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// A check returns an error if its component isn't ready
+type Check func(ctx context.Context) error
+
+// How a single component checked out
+type Component struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     *string `json:"error,omitempty"`
+}
+
+// The overall readiness, which is only ok if every component is
+type Report struct {
+	Status     string       `json:"status"`
+	Components []*Component `json:"components"`
+}
+
+func (r *Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+type check struct {
+	name string
+	run  Check
+}
+
+// Checker runs the readiness checks for the components the app needs...
+//
+// Checks run at the same time, each with Timeout to finish, so one slow component can't hold up the probe.
+type Checker struct {
+	Timeout time.Duration
+	checks  []*check
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{Timeout: timeout}
+}
+
+// Add a component, components are reported in the order they were added
+func (c *Checker) Add(name string, run Check) {
+	c.checks = append(c.checks, &check{name: name, run: run})
+}
+
+// Check every component
+func (c *Checker) Check(ctx context.Context) *Report {
+	report := &Report{Status: StatusOK, Components: make([]*Component, len(c.checks))}
+	var wg sync.WaitGroup
+	for i, ch := range c.checks {
+		wg.Add(1)
+		go func(i int, ch *check) {
+			defer wg.Done()
+			report.Components[i] = c.run(ctx, ch)
+		}(i, ch)
+	}
+	wg.Wait()
+	for _, component := range report.Components {
+		if component.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, ch *check) *Component {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	// The check is left to finish in the background if it overruns...
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- ch.run(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", c.Timeout)
+	}
+
+	component := &Component{
+		Name:      ch.name,
+		Status:    StatusOK,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		msg := err.Error()
+		component.Status = StatusFail
+		component.Error = &msg
+	}
+	return component
+}
+
+// Ping the database through its connection pool
+func Database(tx *gorm.DB) Check {
+	return func(ctx context.Context) error {
+		sqlDB, err := tx.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+}
+
+// Connect to a TCP listener, which is only possible while it is serving
+func Listener(address string) Check {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// Fail while a condition doesn't hold
+func Condition(ok func() bool, reason string) Check {
+	return func(ctx context.Context) error {
+		if !ok() {
+			return errors.New(reason)
+		}
+		return nil
+	}
+}
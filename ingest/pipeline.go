@@ -0,0 +1,310 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/quota"
+	"github.com/kaigoh/loggo/spool"
+	"github.com/kaigoh/loggo/telemetry"
+	"gorm.io/gorm"
+)
+
+// Rows per INSERT when writing a batch
+const insertBatchSize = 100
+
+var ErrQueueFull = errors.New("ingestion queue is full")
+var ErrClosed = errors.New("ingestion pipeline is shut down")
+
+// The events couldn't be written to the database but are safe in the spool, they'll be written once it is back
+var ErrSpooled = errors.New("events spooled until the database is available")
+
+// A set of events from one producer, written together and reported back on done
+type job struct {
+	// How the events reached us, kept with them if they are spooled
+	transport string
+	channel   *models.Channel
+	events    []*models.Event
+	done      chan error
+}
+
+// Pipeline queues new events and writes them to the database in batches...
+//
+// The queue holds at most QueueSize events, once it is full producers are turned away with ErrQueueFull
+// rather than waiting, so a slow database pushes back on whoever is sending events instead of piling up
+// requests. Several writers drain the queue, each taking as many queued jobs as fit in BatchSize events.
+type Pipeline struct {
+	QueueSize int
+	Writers   int
+	BatchSize int
+	// Events which can't be written are spooled to disk when set
+	Spool *spool.Spool
+	tx    *gorm.DB
+	queue chan *job
+	stop  context.CancelFunc
+
+	// Events queued or being written, and whether new ones are still accepted
+	mu      sync.Mutex
+	pending int
+	closed  bool
+	writers sync.WaitGroup
+}
+
+func NewPipeline(config *configuration.Config, tx *gorm.DB) (*Pipeline, error) {
+	if config.Ingest.QueueSize < 1 {
+		return nil, fmt.Errorf("ingest queue size must be at least 1")
+	}
+	if config.Ingest.Writers < 1 {
+		return nil, fmt.Errorf("ingest writers must be at least 1")
+	}
+	var err error
+	batchSize := int(config.Ingest.BatchSize)
+	if batchSize < 1 {
+		batchSize = 500
+	}
+	var spooler *spool.Spool
+	if config.Spool.Enabled {
+		spooler, err = spool.NewSpool(config, tx)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spool configuration: %w", err)
+		}
+	}
+	p := &Pipeline{
+		Spool:     spooler,
+		QueueSize: int(config.Ingest.QueueSize),
+		Writers:   int(config.Ingest.Writers),
+		BatchSize: batchSize,
+		tx:        tx,
+		queue:     make(chan *job, config.Ingest.QueueSize),
+	}
+	telemetry.IngestQueued.Set(func() float64 { return float64(p.Pending()) })
+	return p, nil
+}
+
+// Start the writers, and replaying the spool
+func (p *Pipeline) Start() {
+	for i := 0; i < p.Writers; i++ {
+		p.writers.Add(1)
+		go p.writer()
+	}
+	if p.Spool != nil {
+		var ctx context.Context
+		ctx, p.stop = context.WithCancel(context.Background())
+		go p.Spool.Run(ctx)
+	}
+}
+
+// Events waiting to be written
+func (p *Pipeline) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pending
+}
+
+// Queue events from a channel and wait until they have been written...
+//
+// ErrQueueFull is returned straight away if there isn't room for them, ErrClosed once the pipeline is
+// shutting down, and otherwise whatever error writing them hit. The context is only checked before the
+// events are queued, once queued they will be written so this waits to find out how it went rather than
+// reporting events which were saved as failed (and having them sent again).
+func (p *Pipeline) Submit(ctx context.Context, transport string, channel *models.Channel, events []*models.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrClosed
+	}
+	// A batch bigger than the whole queue is only let in when the queue is empty...
+	if p.pending > 0 && p.pending+len(events) > p.QueueSize {
+		p.mu.Unlock()
+		telemetry.IngestEvents.WithLabelValues(telemetry.ResultQueueFull).Add(float64(len(events)))
+		return ErrQueueFull
+	}
+	j := &job{transport: transport, channel: channel, events: events, done: make(chan error, 1)}
+	select {
+	case p.queue <- j:
+		p.pending += len(events)
+	default:
+		p.mu.Unlock()
+		telemetry.IngestEvents.WithLabelValues(telemetry.ResultQueueFull).Add(float64(len(events)))
+		return ErrQueueFull
+	}
+	p.mu.Unlock()
+
+	return <-j.done
+}
+
+// Stop accepting events and wait for everything queued to be written
+func (p *Pipeline) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if p.stop != nil {
+			p.stop()
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("ingestion queue not flushed, %d events unwritten: %w", p.Pending(), ctx.Err())
+	}
+}
+
+func (p *Pipeline) writer() {
+	defer p.writers.Done()
+	for j := range p.queue {
+		// Take whatever else is already waiting, up to a batch...
+		jobs := []*job{j}
+		size := len(j.events)
+	collect:
+		for size < p.BatchSize {
+			select {
+			case next, ok := <-p.queue:
+				if !ok {
+					break collect
+				}
+				jobs = append(jobs, next)
+				size += len(next.events)
+			default:
+				break collect
+			}
+		}
+		p.write(jobs)
+	}
+}
+
+// Write jobs in one transaction, falling back to one at a time so one bad job doesn't fail the others
+func (p *Pipeline) write(jobs []*job) {
+	// Keep events in order, nothing new goes to the database until the spool has been replayed. If the
+	// database is back the spool is caught up now rather than on its next tick, so new events aren't spooled
+	// behind it needlessly...
+	if p.Spool != nil && p.Spool.Pending() > 0 && p.available() {
+		if n, err := p.Spool.Replay(); err != nil {
+			log.Println("Spool replay stopped after "+strconv.Itoa(n)+" events, will retry", err)
+		}
+	}
+	if p.Spool != nil && p.Spool.Pending() > 0 {
+		for _, j := range jobs {
+			p.finish(j, p.spool(j, nil))
+		}
+		return
+	}
+
+	// Jobs for the same channel are written together, so each has to fit alongside those let in before it...
+	var accepted []*job
+	var events []*models.Event
+	byChannel := map[uint][]*models.Event{}
+	for _, j := range jobs {
+		if err := quota.EnforcePending(p.tx, j.channel, byChannel[j.channel.ID], j.events); err != nil {
+			if !errors.Is(err, quota.ErrQuotaExceeded) {
+				err = p.spool(j, err)
+			}
+			p.finish(j, err)
+			continue
+		}
+		accepted = append(accepted, j)
+		events = append(events, j.events...)
+		byChannel[j.channel.ID] = append(byChannel[j.channel.ID], j.events...)
+	}
+	if len(accepted) == 0 {
+		return
+	}
+
+	err := p.insert(events)
+	if err == nil {
+		for _, j := range accepted {
+			p.finish(j, nil)
+		}
+		return
+	}
+	if len(accepted) > 1 {
+		log.Println("Unable to write batch of "+strconv.Itoa(len(events))+" events, retrying individually", err)
+	}
+	for _, j := range accepted {
+		if len(accepted) > 1 {
+			err = p.insert(j.events)
+		}
+		if err != nil {
+			err = p.spool(j, err)
+		}
+		p.finish(j, err)
+	}
+}
+
+// Spool a job which couldn't be written, returning the error it should finish with. Only an unavailable
+// database is worth spooling for, anything else would fail again on replay so it goes back to the producer.
+func (p *Pipeline) spool(j *job, cause error) error {
+	if p.Spool == nil || (cause != nil && p.available()) {
+		return cause
+	}
+	if err := p.Spool.Write(j.transport, j.events); err != nil {
+		log.Println("Unable to spool events", err)
+		if cause != nil {
+			return cause
+		}
+		return err
+	}
+	return ErrSpooled
+}
+
+func (p *Pipeline) available() bool {
+	sqlDB, err := p.tx.DB()
+	return err == nil && sqlDB.Ping() == nil
+}
+
+func (p *Pipeline) insert(events []*models.Event) error {
+	defer telemetry.ObserveDBWrite(time.Now())
+	err := p.tx.Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(events, insertBatchSize).Error
+	})
+	if err != nil {
+		// The rows were rolled back, so forget any IDs they were given before trying again...
+		for _, e := range events {
+			e.ID = 0
+			e.EventData.ID = 0
+			e.EventData.EventID = 0
+			for i := range e.Attributes {
+				e.Attributes[i].ID = 0
+				e.Attributes[i].EventID = 0
+			}
+		}
+	}
+	return err
+}
+
+func (p *Pipeline) finish(j *job, err error) {
+	p.mu.Lock()
+	p.pending -= len(j.events)
+	p.mu.Unlock()
+	switch {
+	case errors.Is(err, ErrSpooled):
+		telemetry.IngestEvents.WithLabelValues(telemetry.ResultSpooled).Add(float64(len(j.events)))
+	case err != nil:
+		telemetry.IngestEvents.WithLabelValues(telemetry.ResultFailed).Add(float64(len(j.events)))
+	default:
+		telemetry.IngestEvents.WithLabelValues(telemetry.ResultWritten).Add(float64(len(j.events)))
+	}
+	j.done <- err
+}
@@ -0,0 +1,137 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// A part of the app which is started and stopped with it
+type service struct {
+	name   string
+	run    func(ctx context.Context) error
+	stop   func(ctx context.Context) error
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// App runs services until it is told to stop (or one of them fails), then stops them in reverse order...
+//
+// Nothing here exits the process, Run returns once everything has stopped so the caller decides what to do.
+type App struct {
+	// How long stopping every service can take before giving up on the rest
+	Timeout time.Duration
+	// How long to wait once Stopping is set before stopping anything, so whatever watches it (readiness
+	// probes, load balancers) has time to notice. It doesn't count towards the timeout.
+	Grace    time.Duration
+	services []*service
+	stopping int32
+}
+
+func New(timeout time.Duration) *App {
+	return &App{Timeout: timeout}
+}
+
+// Add a service. Run, if set, is started in the background and its context is cancelled when the app
+// stops. Stop, if set, is then called to stop it, after which the app waits for run to return. Services
+// stop in the reverse of the order they were added, so add whatever accepts new work last.
+func (a *App) Add(name string, run func(ctx context.Context) error, stop func(ctx context.Context) error) {
+	a.services = append(a.services, &service{name: name, run: run, stop: stop})
+}
+
+// Run every service until the context is done or one of them fails, then stop them all...
+//
+// The error is whatever made a service fail, or the first error hit while stopping.
+func (a *App) Run(ctx context.Context) error {
+	exited := make(chan *service, len(a.services))
+	for _, s := range a.services {
+		if s.run == nil {
+			continue
+		}
+		var sctx context.Context
+		sctx, s.cancel = context.WithCancel(context.Background())
+		s.done = make(chan struct{})
+		go func(s *service) {
+			s.err = s.run(sctx)
+			close(s.done)
+			exited <- s
+		}(s)
+	}
+
+	// Services which finish without an error (say, once they've started listening) are left alone...
+	var cause error
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Shutting down...")
+			break wait
+		case s := <-exited:
+			if s.err != nil {
+				cause = fmt.Errorf("%s: %w", s.name, s.err)
+				log.Println("Shutting down after "+s.name+" failed", s.err)
+				break wait
+			}
+		}
+	}
+
+	if err := a.shutdown(); err != nil && cause == nil {
+		cause = err
+	}
+	return cause
+}
+
+// Whether the app has started shutting down
+func (a *App) Stopping() bool {
+	return atomic.LoadInt32(&a.stopping) == 1
+}
+
+// Stop every service, newest first, within the timeout
+func (a *App) shutdown() error {
+	atomic.StoreInt32(&a.stopping, 1)
+	if a.Grace > 0 {
+		log.Println("Waiting " + a.Grace.String() + " before stopping services")
+		time.Sleep(a.Grace)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.Timeout)
+	defer cancel()
+
+	var first error
+	for i := len(a.services) - 1; i >= 0; i-- {
+		s := a.services[i]
+		if err := a.stopService(ctx, s); err != nil {
+			log.Println("Unable to stop "+s.name, err)
+			if first == nil {
+				first = fmt.Errorf("%s: %w", s.name, err)
+			}
+		}
+	}
+	return first
+}
+
+func (a *App) stopService(ctx context.Context, s *service) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("no time left to stop: %w", ctx.Err())
+	}
+	log.Println("Stopping " + s.name)
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.stop != nil {
+		if err := s.stop(ctx); err != nil {
+			return err
+		}
+	}
+	if s.done != nil {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for it to stop: %w", ctx.Err())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the order services are stopped in
+type stopLog struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (l *stopLog) stop(name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.names = append(l.names, name)
+		return nil
+	}
+}
+
+func (l *stopLog) order() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.names, ",")
+}
+
+// Runs until its context is cancelled
+func blocking(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestStopsInReverseOrder(t *testing.T) {
+	var l stopLog
+	app := New(time.Second)
+	app.Add("database", nil, l.stop("database"))
+	app.Add("pipeline", blocking, l.stop("pipeline"))
+	app.Add("http", blocking, l.stop("http"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := app.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.order(); got != "http,pipeline,database" {
+		t.Errorf("stopped in the order %s", got)
+	}
+	if !app.Stopping() {
+		t.Error("expected the app to say it is stopping")
+	}
+}
+
+func TestStoppingIsSetBeforeServicesStop(t *testing.T) {
+	app := New(time.Second)
+	var stopping bool
+	app.Add("http", blocking, func(ctx context.Context) error {
+		stopping = app.Stopping()
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := app.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !stopping {
+		t.Error("expected Stopping to be true while services stop")
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	var l stopLog
+	app := New(time.Second)
+	app.Grace = 100 * time.Millisecond
+	app.Add("http", blocking, l.stop("http"))
+
+	done := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- app.Run(ctx) }()
+	cancel()
+
+	// Stopping is set straight away, but nothing stops until the grace period is up...
+	time.Sleep(50 * time.Millisecond)
+	if !app.Stopping() {
+		t.Error("expected the app to say it is stopping during the grace period")
+	}
+	if got := l.order(); got != "" {
+		t.Errorf("stopped %s during the grace period", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := l.order(); got != "http" {
+		t.Errorf("stopped %s", got)
+	}
+}
+
+func TestStopTimeout(t *testing.T) {
+	var l stopLog
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	app := New(50 * time.Millisecond)
+	app.Add("database", nil, l.stop("database"))
+	app.Add("stuck", func(ctx context.Context) error {
+		<-stuck
+		return nil
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	err := app.Run(ctx)
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "stuck:") {
+		t.Errorf("expected the error to name the service, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %s to give up, the timeout is 50ms", elapsed)
+	}
+	if got := l.order(); got != "" {
+		t.Errorf("expected no time left to stop the rest, but stopped %s", got)
+	}
+}
+
+func TestFailingServiceStopsTheRest(t *testing.T) {
+	var l stopLog
+	failure := errors.New("address already in use")
+
+	app := New(time.Second)
+	app.Add("database", nil, l.stop("database"))
+	app.Add("mqtt", blocking, l.stop("mqtt"))
+	app.Add("http", func(ctx context.Context) error {
+		return failure
+	}, l.stop("http"))
+
+	done := make(chan error, 1)
+	go func() { done <- app.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, failure) {
+			t.Fatalf("expected the service's error, got %v", err)
+		}
+		if !strings.HasPrefix(err.Error(), "http:") {
+			t.Errorf("expected the error to name the service, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the app kept running after a service failed")
+	}
+	if got := l.order(); got != "http,mqtt,database" {
+		t.Errorf("stopped in the order %s", got)
+	}
+}
+
+func TestServicesFinishingCleanlyKeepRunning(t *testing.T) {
+	var l stopLog
+	app := New(time.Second)
+	app.Add("listener", func(ctx context.Context) error {
+		return nil
+	}, l.stop("listener"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- app.Run(ctx) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("the app stopped on its own: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := l.order(); got != "listener" {
+		t.Errorf("stopped %s", got)
+	}
+}
@@ -0,0 +1,325 @@
+package loki
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Labels which give the level of a stream's lines, the first found wins
+var levelLabels = []string{"level", "detected_level", "severity", "lvl"}
+
+// Entry is a single log line from a stream
+type Entry struct {
+	Timestamp time.Time
+	Line      string
+	Metadata  map[string]string
+}
+
+// Stream is a set of lines sharing the same labels
+type Stream struct {
+	Labels  map[string]string
+	Entries []*Entry
+}
+
+// Decode a push request, which Promtail sends as snappy compressed protobuf and others send as JSON
+func Decode(body []byte, contentType string) ([]*Stream, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/json" {
+		return decodeJSON(body)
+	}
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snappy compression: %w", err)
+	}
+	return decodeProtobuf(raw)
+}
+
+// Convert streams into new events, in order...
+//
+// The channel comes from the channel label (or the default), the source from the source label and the
+// level from a level label if there is one. The rest of the labels, and any structured metadata, are kept
+// as the event's JSON data.
+func Events(config *configuration.Config, streams []*Stream) []*models.RoutedNewEvent {
+	var events []*models.RoutedNewEvent
+	for _, stream := range streams {
+		labels := map[string]string{}
+		for k, v := range stream.Labels {
+			labels[k] = v
+		}
+
+		channel := labels[config.Loki.ChannelLabel]
+		delete(labels, config.Loki.ChannelLabel)
+		if len(channel) == 0 {
+			channel = config.Loki.DefaultChannel
+		}
+		source := labels[config.Loki.SourceLabel]
+		if len(source) == 0 {
+			source = "loki"
+		}
+		level := models.EventLevelInfo
+		for _, label := range levelLabels {
+			if l, ok := models.ParseEventLevel(labels[label]); ok {
+				level = l
+				break
+			}
+		}
+
+		for _, entry := range stream.Entries {
+			e := &models.RoutedNewEvent{Channel: channel}
+			e.Event, e.Err = newEvent(entry, source, level, labels)
+			if e.Err == nil && len(channel) == 0 {
+				e.Err = fmt.Errorf("no channel, set the '%s' label", config.Loki.ChannelLabel)
+			}
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func newEvent(entry *Entry, source string, level models.EventLevel, labels map[string]string) (*models.NewEvent, error) {
+	ts := entry.Timestamp.UTC().Format(time.RFC3339Nano)
+	n := &models.NewEvent{
+		Source:    source,
+		Level:     level,
+		Message:   entry.Line,
+		Timestamp: &ts,
+	}
+	data := map[string]string{}
+	for k, v := range labels {
+		data[k] = v
+	}
+	for k, v := range entry.Metadata {
+		data[k] = v
+	}
+	if len(data) > 0 {
+		out, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		n.Data = &out
+	}
+	return n, n.Validate()
+}
+
+// {"streams": [{"stream": {"label": "value"}, "values": [["<unix nanoseconds>", "line", {metadata}]]}]}
+func decodeJSON(body []byte) ([]*Stream, error) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid Loki push: %w", err)
+	}
+	streams := make([]*Stream, len(req.Streams))
+	for i, s := range req.Streams {
+		stream := &Stream{Labels: s.Stream}
+		for _, value := range s.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("invalid Loki push: values need a timestamp and a line")
+			}
+			var ts string
+			entry := &Entry{}
+			if err := json.Unmarshal(value[0], &ts); err != nil {
+				return nil, fmt.Errorf("invalid Loki timestamp: %w", err)
+			}
+			ns, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Loki timestamp: %w", err)
+			}
+			entry.Timestamp = time.Unix(0, ns)
+			if err := json.Unmarshal(value[1], &entry.Line); err != nil {
+				return nil, fmt.Errorf("invalid Loki line: %w", err)
+			}
+			if len(value) > 2 {
+				if err := json.Unmarshal(value[2], &entry.Metadata); err != nil {
+					return nil, fmt.Errorf("invalid Loki structured metadata: %w", err)
+				}
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		streams[i] = stream
+	}
+	return streams, nil
+}
+
+// PushRequest is streams = 1, each of which has labels = 1 and entries = 2. An entry has timestamp = 1
+// (a google.protobuf.Timestamp), line = 2 and structured metadata = 3 (name = 1, value = 2).
+func decodeProtobuf(b []byte) ([]*Stream, error) {
+	var streams []*Stream
+	err := eachField(b, func(num protowire.Number, value []byte) error {
+		if num != 1 {
+			return nil
+		}
+		stream, err := decodeStream(value)
+		if err != nil {
+			return err
+		}
+		streams = append(streams, stream)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid Loki push: %w", err)
+	}
+	return streams, nil
+}
+
+func decodeStream(b []byte) (*Stream, error) {
+	stream := &Stream{}
+	err := eachField(b, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			labels, err := ParseLabels(string(value))
+			if err != nil {
+				return err
+			}
+			stream.Labels = labels
+		case 2:
+			entry, err := decodeEntry(value)
+			if err != nil {
+				return err
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		return nil
+	})
+	return stream, err
+}
+
+func decodeEntry(b []byte) (*Entry, error) {
+	entry := &Entry{}
+	err := eachField(b, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			var seconds, nanos uint64
+			err := eachVarint(value, func(num protowire.Number, v uint64) {
+				switch num {
+				case 1:
+					seconds = v
+				case 2:
+					nanos = v
+				}
+			})
+			if err != nil {
+				return err
+			}
+			entry.Timestamp = time.Unix(int64(seconds), int64(nanos))
+		case 2:
+			entry.Line = string(value)
+		case 3:
+			var name, val string
+			err := eachField(value, func(num protowire.Number, v []byte) error {
+				switch num {
+				case 1:
+					name = string(v)
+				case 2:
+					val = string(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if entry.Metadata == nil {
+				entry.Metadata = map[string]string{}
+			}
+			entry.Metadata[name] = val
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// Call fn with each length delimited field of a message, skipping anything else
+func eachField(b []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Call fn with each varint field of a message, skipping anything else
+func eachVarint(b []byte, fn func(num protowire.Number, value uint64)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.VarintType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		fn(num, v)
+	}
+	return nil
+}
+
+// Parse labels written the Prometheus way, {job="varlogs", host="web-1"}
+func ParseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("invalid labels '%s'", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 1 {
+			return nil, fmt.Errorf("invalid labels, expected name=\"value\"")
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = strings.TrimSpace(s[eq+1:])
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for label '%s'", name)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for label '%s'", name)
+		}
+		labels[name] = value
+		s = strings.TrimPrefix(strings.TrimSpace(s[len(quoted):]), ",")
+		s = strings.TrimSpace(s)
+	}
+	return labels, nil
+}
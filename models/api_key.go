@@ -0,0 +1,187 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Prefix for every issued token, makes them easy to spot in configs and logs
+const APIKeyTokenPrefix = "loggo_"
+
+type APIKey struct {
+	ID         uint          `gorm:"primaryKey" json:"id"`
+	Name       string        `gorm:"index:idx_loggo_api_key_name,unique; size:128; not null;" json:"name"`
+	Prefix     string        `gorm:"size:16; not null;" json:"prefix"`
+	Hash       string        `gorm:"index:idx_loggo_api_key_hash,unique; size:64; not null;" json:"-"`
+	CreatedAt  time.Time     `json:"created_at"`
+	LastUsedAt *time.Time    `json:"last_used_at"`
+	RevokedAt  *time.Time    `json:"revoked_at"`
+	Scopes     []APIKeyScope `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"scopes"`
+}
+
+// A single permission on a single channel, a nil channel applies to every channel
+type APIKeyScope struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	APIKeyID   uint       `gorm:"index:idx_loggo_api_key_scope; not null;" json:"-"`
+	ChannelID  *uint      `gorm:"index:idx_loggo_api_key_scope_channel;" json:"channel_id"`
+	Channel    *Channel   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	Permission Permission `gorm:"size:16; not null;" json:"permission"`
+}
+
+type NewAPIKey struct {
+	Name   string            `json:"name"`
+	Scopes []*NewAPIKeyScope `json:"scopes"`
+}
+
+type NewAPIKeyScope struct {
+	ChannelID   *uint        `json:"channelId"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// Returned once when a key is issued, the token itself is never stored
+type IssuedAPIKey struct {
+	Token string  `json:"token"`
+	Key   *APIKey `json:"key"`
+}
+
+// Create a new key and its plain text token
+func (n *NewAPIKey) ToAPIKey() (key APIKey, token string, err error) {
+	if len(n.Name) == 0 {
+		return key, "", fmt.Errorf("API key name cannot be empty")
+	}
+	// Names double as MQTT usernames, where a token can also be given as the username...
+	if strings.HasPrefix(n.Name, APIKeyTokenPrefix) {
+		return key, "", fmt.Errorf("API key name cannot start with '%s'", APIKeyTokenPrefix)
+	}
+	for _, s := range n.Scopes {
+		for _, p := range s.Permissions {
+			key.Scopes = append(key.Scopes, APIKeyScope{ChannelID: s.ChannelID, Permission: p})
+		}
+	}
+	// Scopes with no permissions don't count...
+	if len(key.Scopes) == 0 {
+		return key, "", fmt.Errorf("API key must have at least one scope")
+	}
+
+	b := make([]byte, 24)
+	if _, err = rand.Read(b); err != nil {
+		return key, "", err
+	}
+	token = APIKeyTokenPrefix + hex.EncodeToString(b)
+
+	key.Name = n.Name
+	key.Prefix = token[:len(APIKeyTokenPrefix)+6]
+	key.Hash = HashAPIKeyToken(token)
+	return key, token, nil
+}
+
+// Tokens are random, so a plain SHA-256 is enough to avoid storing them
+func HashAPIKeyToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Does the key grant the permission on the channel? A nil channel asks for the permission across every channel
+func (k *APIKey) Allows(channelID *uint, permission Permission) bool {
+	for _, s := range k.Scopes {
+		if !s.Permission.Grants(permission) {
+			continue
+		}
+		if s.ChannelID == nil || (channelID != nil && *s.ChannelID == *channelID) {
+			return true
+		}
+	}
+	return false
+}
+
+// The channels the key grants the permission on, all is true when it isn't limited to specific channels
+func (k *APIKey) Channels(permission Permission) (ids []uint, all bool) {
+	for _, s := range k.Scopes {
+		if !s.Permission.Grants(permission) {
+			continue
+		}
+		if s.ChannelID == nil {
+			return nil, true
+		}
+		ids = append(ids, *s.ChannelID)
+	}
+	return ids, false
+}
+
+func APIKeyByToken(tx *gorm.DB, token string) (key *APIKey, err error) {
+	result := tx.Preload("Scopes").Where("hash = ? AND revoked_at IS NULL", HashAPIKeyToken(token)).Limit(1).Find(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("API key not found")
+	}
+	return
+}
+
+func APIKeyByName(tx *gorm.DB, name string) (key *APIKey, err error) {
+	result := tx.Preload("Scopes").Where("name = ? AND revoked_at IS NULL", name).Limit(1).Find(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("API key not found")
+	}
+	return
+}
+
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+	PermissionAdmin Permission = "admin"
+)
+
+var AllPermission = []Permission{
+	PermissionRead,
+	PermissionWrite,
+	PermissionAdmin,
+}
+
+// Admin implies read and write, read and write are independent of each other
+func (e Permission) Grants(requested Permission) bool {
+	return e == requested || e == PermissionAdmin
+}
+
+func (e Permission) IsValid() bool {
+	switch e {
+	case PermissionRead, PermissionWrite, PermissionAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Permission) String() string {
+	return string(e)
+}
+
+func (e *Permission) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Permission(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Permission", str)
+	}
+	return nil
+}
+
+func (e Permission) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -0,0 +1,18 @@
+package models
+
+import "testing"
+
+func TestToAPIKeyNeedsPermissions(t *testing.T) {
+	for _, n := range []*NewAPIKey{
+		{Name: "none"},
+		{Name: "empty", Scopes: []*NewAPIKeyScope{{Permissions: []Permission{}}}},
+	} {
+		if _, _, err := n.ToAPIKey(); err == nil {
+			t.Errorf("%s: expected a key without any permissions to be refused", n.Name)
+		}
+	}
+	n := &NewAPIKey{Name: "reader", Scopes: []*NewAPIKeyScope{{Permissions: []Permission{PermissionRead}}}}
+	if key, _, err := n.ToAPIKey(); err != nil || len(key.Scopes) != 1 {
+		t.Errorf("expected one scope, got %v (%v)", key.Scopes, err)
+	}
+}
@@ -1,24 +1,37 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/kaigoh/loggo/configuration"
 	"gorm.io/gorm"
 )
 
+var ErrChannelNotFound = errors.New("channel not found")
+
+// The level TTLs override the TTL for events of that level, a nil quota limit is unlimited
 type Channel struct {
-	ID        uint    `gorm:"primaryKey" json:"id"`
-	UUID      string  `gorm:"index:idx_loggo_channel_uuid,unique; size:64; not null; column:uuid;" json:"uuid"`
-	Name      string  `gorm:"index:idx_loggo_channel_name,unique; size:128; not null;" json:"name"`
-	TTL       *string `gorm:"size:64;" json:"ttl"`
-	MQTT      bool    `gorm:"default:true; column:mqtt_enabled; not null;" json:"mqtt"`
-	MQTTTopic *string `gorm:"column:mqtt_topic;" json:"mqtt_topic"`
-	Ntfy      bool    `gorm:"default:true; column:ntfy_enabled; not null;" json:"ntfy"`
-	NtfyTopic *string `gorm:"column:ntfy_topic;" json:"ntfy_topic"`
-	Events    []Event `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UUID         string    `gorm:"index:idx_loggo_channel_uuid,unique; size:64; not null; column:uuid;" json:"uuid"`
+	Name         string    `gorm:"index:idx_loggo_channel_name,unique; size:128; not null;" json:"name"`
+	TTL          *string   `gorm:"size:64;" json:"ttl"`
+	DebugTTL     *string   `gorm:"size:64; column:debug_ttl;" json:"debug_ttl"`
+	InfoTTL      *string   `gorm:"size:64; column:info_ttl;" json:"info_ttl"`
+	WarningTTL   *string   `gorm:"size:64; column:warning_ttl;" json:"warning_ttl"`
+	ErrorTTL     *string   `gorm:"size:64; column:error_ttl;" json:"error_ttl"`
+	FatalTTL     *string   `gorm:"size:64; column:fatal_ttl;" json:"fatal_ttl"`
+	MaxEvents    *int64    `gorm:"column:max_events;" json:"max_events"`
+	MaxDataBytes *int64    `gorm:"column:max_data_bytes;" json:"max_data_bytes"`
+	QuotaMode    QuotaMode `gorm:"size:16; default:reject_new; column:quota_mode; not null;" json:"quota_mode"`
+	MQTT         bool      `gorm:"default:true; column:mqtt_enabled; not null;" json:"mqtt"`
+	MQTTTopic    *string   `gorm:"column:mqtt_topic;" json:"mqtt_topic"`
+	Ntfy         bool      `gorm:"default:true; column:ntfy_enabled; not null;" json:"ntfy"`
+	NtfyTopic    *string   `gorm:"column:ntfy_topic;" json:"ntfy_topic"`
+	Events       []Event   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
 }
 
 func (c *Channel) AfterFind(tx *gorm.DB) (err error) {
@@ -36,42 +49,304 @@ func (c *Channel) AfterFind(tx *gorm.DB) (err error) {
 
 }
 
-func (c *Channel) GetTTL(config *configuration.Config) (time.Duration, error) {
+// The TTL for events of a level, the channels own settings win over configured defaults...
+//
+// The channels override for the level, then the channel TTL, then the configured default for the level
+// and finally the configured default TTL. An empty (or unknown) level skips the per level settings.
+func (c *Channel) GetTTL(config *configuration.Config, level EventLevel) (time.Duration, error) {
+	if ttl := c.LevelTTL(level); ttl != nil {
+		return time.ParseDuration(*ttl)
+	}
 	if c.TTL != nil {
 		return time.ParseDuration(*c.TTL)
 	}
+	if ttl := config.GetLevelTTL(level.String()); len(ttl) > 0 {
+		return time.ParseDuration(ttl)
+	}
 	return time.ParseDuration(config.DefaultEntryTTL)
 }
 
+// The channels TTL override for a level, nil if it doesn't have one
+func (c *Channel) LevelTTL(level EventLevel) *string {
+	switch level {
+	case EventLevelDebug:
+		return c.DebugTTL
+	case EventLevelInfo:
+		return c.InfoTTL
+	case EventLevelWarning:
+		return c.WarningTTL
+	case EventLevelError:
+		return c.ErrorTTL
+	case EventLevelFatal:
+		return c.FatalTTL
+	}
+	return nil
+}
+
+// The TTL which applies to each level of the channel, for display
+func (c *Channel) LevelTTLs(config *configuration.Config) (ttls []*LevelTTL, err error) {
+	for _, level := range AllEventLevel {
+		ttl, err := c.GetTTL(config, level)
+		if err != nil {
+			return nil, err
+		}
+		ttls = append(ttls, &LevelTTL{Level: level, Override: c.LevelTTL(level), TTL: ttl.String()})
+	}
+	return
+}
+
+// The effective TTL of a level, override is only set when the channel has its own TTL for the level
+type LevelTTL struct {
+	Level    EventLevel `json:"level"`
+	TTL      string     `json:"ttl"`
+	Override *string    `json:"override"`
+}
+
+// Names (and topics below) are matched exactly but ignoring case, LIKE would treat _ and % as wildcards
 func ChannelByName(tx *gorm.DB, name string) (channel *Channel, err error) {
-	result := tx.Where("name LIKE ?", name).Find(&channel)
+	result := tx.Where("LOWER(name) = LOWER(?)", name).Limit(1).Find(&channel)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrChannelNotFound
+	}
+	return
+}
+
+func ChannelByID(tx *gorm.DB, id uint) (channel *Channel, err error) {
+	result := tx.Where("id = ?", id).Limit(1).Find(&channel)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	if result.RowsAffected == 0 {
-		return nil, fmt.Errorf("channel not found")
+		return nil, ErrChannelNotFound
+	}
+	return
+}
+
+func ChannelByUUID(tx *gorm.DB, uuid string) (channel *Channel, err error) {
+	result := tx.Where("uuid = ?", uuid).Limit(1).Find(&channel)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrChannelNotFound
 	}
 	return
 }
 
 func ChannelByMQTTTopic(tx *gorm.DB, topic string) (channel *Channel, err error) {
-	result := tx.Where("(mqtt_topic LIKE ?) OR (name LIKE ? AND mqtt_topic IS NULL)", topic, topic).Limit(1).Find(&channel)
+	result := tx.Where("(LOWER(mqtt_topic) = LOWER(?)) OR (LOWER(name) = LOWER(?) AND mqtt_topic IS NULL)", topic, topic).Limit(1).Find(&channel)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	if result.RowsAffected == 0 {
-		return nil, fmt.Errorf("channel not found")
+		return nil, ErrChannelNotFound
 	}
 	return
 }
 
 func ChannelByNtfyTopic(tx *gorm.DB, topic string) (channel *Channel, err error) {
-	result := tx.Where("(ntfy_topic LIKE ?) OR (name LIKE ? AND ntfy_topic IS NULL)", topic, topic).Limit(1).Find(&channel)
+	result := tx.Where("(LOWER(ntfy_topic) = LOWER(?)) OR (LOWER(name) = LOWER(?) AND ntfy_topic IS NULL)", topic, topic).Limit(1).Find(&channel)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	if result.RowsAffected == 0 {
-		return nil, fmt.Errorf("channel not found")
+		return nil, ErrChannelNotFound
 	}
 	return
 }
+
+type NewChannel struct {
+	Name         string     `json:"name"`
+	TTL          *string    `json:"ttl"`
+	DebugTTL     *string    `json:"debugTtl"`
+	InfoTTL      *string    `json:"infoTtl"`
+	WarningTTL   *string    `json:"warningTtl"`
+	ErrorTTL     *string    `json:"errorTtl"`
+	FatalTTL     *string    `json:"fatalTtl"`
+	MaxEvents    *int64     `json:"maxEvents"`
+	MaxDataBytes *int64     `json:"maxDataBytes"`
+	QuotaMode    *QuotaMode `json:"quotaMode"`
+	MQTT         *bool      `json:"mqtt"`
+	MQTTTopic    *string    `json:"mqttTopic"`
+	Ntfy         *bool      `json:"ntfy"`
+	NtfyTopic    *string    `json:"ntfyTopic"`
+}
+
+type UpdateChannel struct {
+	Name         *string    `json:"name"`
+	TTL          *string    `json:"ttl"`
+	DebugTTL     *string    `json:"debugTtl"`
+	InfoTTL      *string    `json:"infoTtl"`
+	WarningTTL   *string    `json:"warningTtl"`
+	ErrorTTL     *string    `json:"errorTtl"`
+	FatalTTL     *string    `json:"fatalTtl"`
+	MaxEvents    *int64     `json:"maxEvents"`
+	MaxDataBytes *int64     `json:"maxDataBytes"`
+	QuotaMode    *QuotaMode `json:"quotaMode"`
+	MQTT         *bool      `json:"mqtt"`
+	MQTTTopic    *string    `json:"mqttTopic"`
+	Ntfy         *bool      `json:"ntfy"`
+	NtfyTopic    *string    `json:"ntfyTopic"`
+}
+
+func (n *NewChannel) ToChannel() (channel Channel, err error) {
+	channel.UUID = uuid.NewString()
+	channel.Name = strings.TrimSpace(n.Name)
+	channel.TTL = optionalString(n.TTL)
+	channel.DebugTTL = optionalString(n.DebugTTL)
+	channel.InfoTTL = optionalString(n.InfoTTL)
+	channel.WarningTTL = optionalString(n.WarningTTL)
+	channel.ErrorTTL = optionalString(n.ErrorTTL)
+	channel.FatalTTL = optionalString(n.FatalTTL)
+	channel.MaxEvents = optionalLimit(n.MaxEvents)
+	channel.MaxDataBytes = optionalLimit(n.MaxDataBytes)
+	channel.QuotaMode = QuotaModeRejectNew
+	if n.QuotaMode != nil {
+		channel.QuotaMode = *n.QuotaMode
+	}
+	channel.MQTT = true
+	if n.MQTT != nil {
+		channel.MQTT = *n.MQTT
+	}
+	channel.MQTTTopic = optionalString(n.MQTTTopic)
+	channel.Ntfy = true
+	if n.Ntfy != nil {
+		channel.Ntfy = *n.Ntfy
+	}
+	channel.NtfyTopic = optionalString(n.NtfyTopic)
+	return
+}
+
+// Apply the requested changes to an existing channel, returning the columns which need to be updated...
+func (u *UpdateChannel) Apply(channel *Channel) map[string]interface{} {
+	changes := map[string]interface{}{}
+	if u.Name != nil {
+		channel.Name = strings.TrimSpace(*u.Name)
+		changes["name"] = channel.Name
+	}
+	if u.TTL != nil {
+		channel.TTL = optionalString(u.TTL)
+		changes["ttl"] = channel.TTL
+	}
+	if u.DebugTTL != nil {
+		channel.DebugTTL = optionalString(u.DebugTTL)
+		changes["debug_ttl"] = channel.DebugTTL
+	}
+	if u.InfoTTL != nil {
+		channel.InfoTTL = optionalString(u.InfoTTL)
+		changes["info_ttl"] = channel.InfoTTL
+	}
+	if u.WarningTTL != nil {
+		channel.WarningTTL = optionalString(u.WarningTTL)
+		changes["warning_ttl"] = channel.WarningTTL
+	}
+	if u.ErrorTTL != nil {
+		channel.ErrorTTL = optionalString(u.ErrorTTL)
+		changes["error_ttl"] = channel.ErrorTTL
+	}
+	if u.FatalTTL != nil {
+		channel.FatalTTL = optionalString(u.FatalTTL)
+		changes["fatal_ttl"] = channel.FatalTTL
+	}
+	if u.MaxEvents != nil {
+		channel.MaxEvents = optionalLimit(u.MaxEvents)
+		changes["max_events"] = channel.MaxEvents
+	}
+	if u.MaxDataBytes != nil {
+		channel.MaxDataBytes = optionalLimit(u.MaxDataBytes)
+		changes["max_data_bytes"] = channel.MaxDataBytes
+	}
+	if u.QuotaMode != nil {
+		channel.QuotaMode = *u.QuotaMode
+		changes["quota_mode"] = channel.QuotaMode
+	}
+	if u.MQTT != nil {
+		channel.MQTT = *u.MQTT
+		changes["mqtt_enabled"] = channel.MQTT
+	}
+	if u.MQTTTopic != nil {
+		channel.MQTTTopic = optionalString(u.MQTTTopic)
+		changes["mqtt_topic"] = channel.MQTTTopic
+	}
+	if u.Ntfy != nil {
+		channel.Ntfy = *u.Ntfy
+		changes["ntfy_enabled"] = channel.Ntfy
+	}
+	if u.NtfyTopic != nil {
+		channel.NtfyTopic = optionalString(u.NtfyTopic)
+		changes["ntfy_topic"] = channel.NtfyTopic
+	}
+	return changes
+}
+
+// Check that a channel is valid and doesn't clash with any other channel
+func (c *Channel) Validate(tx *gorm.DB) error {
+	if len(c.Name) == 0 {
+		return fmt.Errorf("channel name cannot be empty")
+	}
+	if c.TTL != nil {
+		if _, err := time.ParseDuration(*c.TTL); err != nil {
+			return fmt.Errorf("invalid TTL '%s': %w", *c.TTL, err)
+		}
+	}
+	for _, level := range AllEventLevel {
+		if ttl := c.LevelTTL(level); ttl != nil {
+			if _, err := time.ParseDuration(*ttl); err != nil {
+				return fmt.Errorf("invalid %s TTL '%s': %w", level, *ttl, err)
+			}
+		}
+	}
+	if c.MaxEvents != nil && *c.MaxEvents < 0 {
+		return fmt.Errorf("maximum events cannot be negative")
+	}
+	if c.MaxDataBytes != nil && *c.MaxDataBytes < 0 {
+		return fmt.Errorf("maximum data bytes cannot be negative")
+	}
+	if !c.QuotaMode.IsValid() {
+		return fmt.Errorf("%s is not a valid QuotaMode", c.QuotaMode)
+	}
+	if other, err := ChannelByName(tx, c.Name); err == nil && other.ID != c.ID {
+		return fmt.Errorf("a channel named '%s' already exists", c.Name)
+	}
+
+	// Topics default to the channel name, so check the topic the channel will actually use...
+	topic := strings.ToLower(c.Name)
+	if c.MQTTTopic != nil {
+		topic = *c.MQTTTopic
+	}
+	if other, err := ChannelByMQTTTopic(tx, topic); err == nil && other.ID != c.ID {
+		return fmt.Errorf("MQTT topic '%s' is already used by channel '%s'", topic, other.Name)
+	}
+	topic = strings.ToLower(c.Name)
+	if c.NtfyTopic != nil {
+		topic = *c.NtfyTopic
+	}
+	if other, err := ChannelByNtfyTopic(tx, topic); err == nil && other.ID != c.ID {
+		return fmt.Errorf("ntfy topic '%s' is already used by channel '%s'", topic, other.Name)
+	}
+
+	return nil
+}
+
+// Blank strings are stored as NULL so the default is used instead...
+func optionalString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*s)
+	if len(trimmed) == 0 {
+		return nil
+	}
+	return &trimmed
+}
+
+// Zero is stored as NULL, so it removes the limit...
+func optionalLimit(i *int64) *int64 {
+	if i == nil || *i == 0 {
+		return nil
+	}
+	return i
+}
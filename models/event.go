@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kaigoh/loggo/configuration"
@@ -14,16 +15,23 @@ import (
 
 type Event struct {
 	ID        uint       `gorm:"primaryKey" json:"id"`
-	ChannelID uint       `gorm:"index:idx_loggo_event_channel; index:idx_loggo_event,0; not null;" json:"channel_id"`
+	ChannelID uint       `gorm:"index:idx_loggo_event_channel; index:idx_loggo_event,0; index:idx_loggo_event_time,0; not null;" json:"channel_id"`
 	Channel   Channel    `json:"-"`
 	CreatedAt time.Time  `json:"created_at"`
 	Source    string     `gorm:"index:idx_loggo_event,1; not null; size:128;" json:"source"`
 	Level     EventLevel `gorm:"index:idx_loggo_event,2; not null;" json:"level"`
-	Timestamp time.Time  `gorm:"index:idx_loggo_event,3,sort:desc; not null;" json:"timestamp"`
+	Timestamp time.Time  `gorm:"index:idx_loggo_event,3,sort:desc; index:idx_loggo_event_time,1; not null;" json:"timestamp"`
 	Title     *string    `gorm:"size:128;" json:"title"`
 	Message   string     `gorm:"size:512; not null;" json:"message"`
 	HasData   bool       `gorm:"not null" json:"has_data"`
+	SpoolID   *string    `gorm:"index:idx_loggo_event_spool; size:36; column:spool_id;" json:"spool_id,omitempty"`
 	EventData EventData  `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"-"`
+	// Only loaded when asked for, new events are saved with theirs
+	Attributes []EventAttribute `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"attributes,omitempty"`
+	// Ties events together across channels, trace and span IDs are W3C trace context
+	TraceID       *string `gorm:"index:idx_loggo_event_trace; size:32;" json:"trace_id,omitempty"`
+	SpanID        *string `gorm:"size:16;" json:"span_id,omitempty"`
+	CorrelationID *string `gorm:"index:idx_loggo_event_correlation; size:128;" json:"correlation_id,omitempty"`
 }
 
 func (e *Event) BeforeCreate(tx *gorm.DB) (err error) {
@@ -64,6 +72,13 @@ type EventData struct {
 	EventID      uint
 	DataMIMEType string `gorm:"size:128; column:data_mime_type; not null;" json:"data_mime_type"`
 	Data         []byte `json:"data"`
+	Size         int64  `gorm:"not null; default:0;" json:"size"`
+}
+
+// Keep the size of the payload so quotas don't have to read it...
+func (d *EventData) BeforeSave(tx *gorm.DB) (err error) {
+	d.Size = int64(len(d.Data))
+	return
 }
 
 type EventLevel string
@@ -92,10 +107,37 @@ func (e EventLevel) IsValid() bool {
 	return false
 }
 
+// Severity of the level, higher is more severe (unknown levels are -1)
+func (e EventLevel) Severity() int {
+	for i, l := range AllEventLevel {
+		if l == e {
+			return i
+		}
+	}
+	return -1
+}
+
 func (e EventLevel) String() string {
 	return string(e)
 }
 
+// The level for the names other loggers use (WARN, err, critical...), false if it isn't one
+func ParseEventLevel(name string) (EventLevel, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "trace", "debug", "dbug":
+		return EventLevelDebug, true
+	case "info", "information", "informational", "notice":
+		return EventLevelInfo, true
+	case "warn", "warning":
+		return EventLevelWarning, true
+	case "err", "error", "eror":
+		return EventLevelError, true
+	case "fatal", "crit", "critical", "alert", "emerg", "emergency", "panic":
+		return EventLevelFatal, true
+	}
+	return "", false
+}
+
 func (e *EventLevel) UnmarshalGQL(v interface{}) error {
 	str, ok := v.(string)
 	if !ok {
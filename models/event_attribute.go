@@ -0,0 +1,130 @@
+package models
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Limits on attributes, so they stay small enough to index
+const (
+	MaxEventAttributes       = 64
+	MaxEventAttributeKey     = 128
+	MaxEventAttributeValue   = 255
+	AttributeHeaderPrefix    = "Loggo-Attr-"
+	AttributeParameterPrefix = "attr."
+)
+
+// A key/value attribute of an event (host, version, request_id...), kept in a table of their own so
+// events can be filtered on them
+type EventAttribute struct {
+	ID      uint   `gorm:"primaryKey" json:"-"`
+	EventID uint   `gorm:"index:idx_loggo_event_attribute_event; not null;" json:"-"`
+	Key     string `gorm:"column:name; index:idx_loggo_event_attribute,0; size:128; not null;" json:"key"`
+	Value   string `gorm:"index:idx_loggo_event_attribute,1; size:255; not null;" json:"value"`
+}
+
+// Attributes of a new event, by key
+type Attributes map[string]string
+
+// Check there aren't too many attributes, or any which are too big
+func (a Attributes) Validate() error {
+	if len(a) > MaxEventAttributes {
+		return fmt.Errorf("an event cannot have more than %d attributes", MaxEventAttributes)
+	}
+	for k, v := range a {
+		if len(strings.TrimSpace(k)) == 0 {
+			return fmt.Errorf("attribute keys cannot be empty")
+		}
+		if len(k) > MaxEventAttributeKey {
+			return fmt.Errorf("attribute key '%s' is longer than %d characters", k, MaxEventAttributeKey)
+		}
+		if len(v) > MaxEventAttributeValue {
+			return fmt.Errorf("attribute '%s' is longer than %d characters", k, MaxEventAttributeValue)
+		}
+	}
+	return nil
+}
+
+// Add attributes which aren't already set
+func (a *Attributes) Merge(other Attributes) {
+	if len(other) == 0 {
+		return
+	}
+	if *a == nil {
+		*a = Attributes{}
+	}
+	for k, v := range other {
+		if _, ok := (*a)[k]; !ok {
+			(*a)[k] = v
+		}
+	}
+}
+
+// The attributes as they are stored, sorted by key
+func (a Attributes) ToEventAttributes() []EventAttribute {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]EventAttribute, len(keys))
+	for i, k := range keys {
+		attrs[i] = EventAttribute{Key: k, Value: a[k]}
+	}
+	return attrs
+}
+
+// XML has no maps, so attributes are an element per key: <attributes><host>web-1</host></attributes>
+func (a *Attributes) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var elements struct {
+		Attributes []struct {
+			XMLName xml.Name
+			Value   string `xml:",chardata"`
+		} `xml:",any"`
+	}
+	if err := d.DecodeElement(&elements, &start); err != nil {
+		return err
+	}
+	*a = Attributes{}
+	for _, e := range elements.Attributes {
+		(*a)[e.XMLName.Local] = e.Value
+	}
+	return nil
+}
+
+// Attributes from Loggo-Attr-<key> headers, keys are lower case as headers don't keep their case
+func HeaderAttributes(h http.Header) Attributes {
+	var a Attributes
+	for name, values := range h {
+		if len(values) == 0 || len(name) <= len(AttributeHeaderPrefix) || !strings.EqualFold(name[:len(AttributeHeaderPrefix)], AttributeHeaderPrefix) {
+			continue
+		}
+		if a == nil {
+			a = Attributes{}
+		}
+		a[strings.ToLower(name[len(AttributeHeaderPrefix):])] = values[0]
+	}
+	return a
+}
+
+// Attributes from attr.<key> query parameters, which are removed from the parameters
+func ParameterAttributes(params url.Values) Attributes {
+	var a Attributes
+	for name, values := range params {
+		if len(values) == 0 || !strings.HasPrefix(strings.ToLower(name), AttributeParameterPrefix) {
+			continue
+		}
+		if key := name[len(AttributeParameterPrefix):]; len(key) > 0 {
+			if a == nil {
+				a = Attributes{}
+			}
+			a[key] = values[0]
+		}
+		delete(params, name)
+	}
+	return a
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type PageInfo struct {
+	HasNextPage     bool    `json:"hasNextPage"`
+	HasPreviousPage bool    `json:"hasPreviousPage"`
+	StartCursor     *string `json:"startCursor"`
+	EndCursor       *string `json:"endCursor"`
+}
+
+type EventEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Event `json:"node"`
+}
+
+type EventConnection struct {
+	Edges      []*EventEdge `json:"edges"`
+	PageInfo   *PageInfo    `json:"pageInfo"`
+	TotalCount int64        `json:"totalCount"`
+}
+
+// Position of an event in the newest first ordering, the ID breaks ties between identical timestamps
+type EventCursor struct {
+	Timestamp time.Time
+	ID        uint
+}
+
+func CursorForEvent(e *Event) EventCursor {
+	return EventCursor{Timestamp: e.Timestamp, ID: e.ID}
+}
+
+// Cursors are opaque to clients, so just base64 the timestamp and ID
+func (c EventCursor) Encode() string {
+	raw := strconv.FormatInt(c.Timestamp.UnixNano(), 10) + ":" + strconv.FormatUint(uint64(c.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeEventCursor(cursor string) (c EventCursor, err error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	c.Timestamp = time.Unix(0, ns)
+	c.ID = uint(id)
+	return c, nil
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type EventFilter struct {
+	ChannelIDs     []uint             `json:"channelIds"`
+	Sources        []string           `json:"sources"`
+	SourcePrefixes []string           `json:"sourcePrefixes"`
+	Levels         []EventLevel       `json:"levels"`
+	MinLevel       *EventLevel        `json:"minLevel"`
+	From           *time.Time         `json:"from"`
+	To             *time.Time         `json:"to"`
+	Text           *string            `json:"text"`
+	HasData        *bool              `json:"hasData"`
+	Attributes     []*AttributeFilter `json:"attributes"`
+	TraceID        *string            `json:"traceId"`
+	CorrelationID  *string            `json:"correlationId"`
+}
+
+// Matches events with an attribute, with a particular value if one is given
+type AttributeFilter struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+// The levels an event can have to match, nil if any level will do
+func (f *EventFilter) MatchingLevels() []EventLevel {
+	if len(f.Levels) == 0 && f.MinLevel == nil {
+		return nil
+	}
+	candidates := f.Levels
+	if len(candidates) == 0 {
+		candidates = AllEventLevel
+	}
+	levels := []EventLevel{}
+	for _, l := range candidates {
+		if f.MinLevel == nil || l.Severity() >= f.MinLevel.Severity() {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+// Scope for filtering events...
+//
+// Conditions are built in the same order as idx_loggo_event (channel, source, level, timestamp), and
+// minimum levels are expanded to a list so the index can still be used for them.
+func (f *EventFilter) Scope(tx *gorm.DB) *gorm.DB {
+	if f.ChannelIDs != nil {
+		tx = tx.Where("channel_id IN ?", f.ChannelIDs)
+	}
+	if len(f.Sources) > 0 || len(f.SourcePrefixes) > 0 {
+		sources := tx.Session(&gorm.Session{NewDB: true})
+		if len(f.Sources) > 0 {
+			sources = sources.Or("source IN ?", f.Sources)
+		}
+		for _, p := range f.SourcePrefixes {
+			sources = sources.Or("source LIKE ? ESCAPE '!'", EscapeLike(p)+"%")
+		}
+		tx = tx.Where(sources)
+	}
+	if levels := f.MatchingLevels(); levels != nil {
+		tx = tx.Where("level IN ?", levels)
+	}
+	if f.From != nil {
+		tx = tx.Where("timestamp >= ?", *f.From)
+	}
+	if f.To != nil {
+		tx = tx.Where("timestamp <= ?", *f.To)
+	}
+	if f.Text != nil && len(*f.Text) > 0 {
+		like := "%" + EscapeLike(*f.Text) + "%"
+		tx = tx.Where("(title LIKE ? ESCAPE '!' OR message LIKE ? ESCAPE '!')", like, like)
+	}
+	if f.HasData != nil {
+		tx = tx.Where("has_data = ?", *f.HasData)
+	}
+	if f.TraceID != nil {
+		tx = tx.Where("trace_id = ?", strings.ToLower(*f.TraceID))
+	}
+	if f.CorrelationID != nil {
+		tx = tx.Where("correlation_id = ?", *f.CorrelationID)
+	}
+	for _, a := range f.Attributes {
+		if a.Value != nil {
+			tx = tx.Where("id IN (SELECT event_id FROM event_attributes WHERE name = ? AND value = ?)", a.Key, *a.Value)
+		} else {
+			tx = tx.Where("id IN (SELECT event_id FROM event_attributes WHERE name = ?)", a.Key)
+		}
+	}
+	return tx
+}
+
+// Escape LIKE wildcards, using ! as the escape character as backslashes mean different things to different databases
+func EscapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -21,13 +21,18 @@ type NewEvent struct {
 	Message      string     `binding:"required" header:"Loggo-Message" form:"message" json:"message" xml:"message" yaml:"message" toml:"message"`
 	DataMIMEType *string    `json:"-"`
 	Data         *[]byte    `form:"data" json:"data" xml:"data" yaml:"data" toml:"data"`
+	Attributes   Attributes `form:"-" header:"-" json:"attributes" xml:"attributes" yaml:"attributes" toml:"attributes"`
+	// Trace context, headers and parameters can also give it as a W3C traceparent
+	TraceID       *string `header:"Loggo-Trace-ID" form:"trace_id" json:"trace_id" xml:"trace_id" yaml:"trace_id" toml:"trace_id"`
+	SpanID        *string `header:"Loggo-Span-ID" form:"span_id" json:"span_id" xml:"span_id" yaml:"span_id" toml:"span_id"`
+	CorrelationID *string `header:"Loggo-Correlation-ID" form:"correlation_id" json:"correlation_id" xml:"correlation_id" yaml:"correlation_id" toml:"correlation_id"`
 }
 
 func (e *NewEvent) GetTimestamp() (timestamp time.Time, err error) {
 	if e.Timestamp != nil {
 		ts := *e.Timestamp
 		if len(strings.TrimSpace(ts)) > 0 {
-			return time.Parse(*e.Timestamp, "RFC3339Nano")
+			return time.Parse(time.RFC3339Nano, ts)
 		}
 	}
 	return time.Now(), nil
@@ -36,10 +41,21 @@ func (e *NewEvent) GetTimestamp() (timestamp time.Time, err error) {
 func (e *NewEvent) ToEvent() (event Event, err error) {
 	event.ChannelID = e.ChannelID
 	event.Timestamp, err = e.GetTimestamp()
+	// Attributes and trace context can come from headers and topic parameters too, so check them once they're all in...
+	if err == nil {
+		err = e.Attributes.Validate()
+	}
+	if err == nil {
+		err = e.validateTrace()
+	}
 	event.Source = e.Source
 	event.Level = e.Level
 	event.Title = e.Title
 	event.Message = e.Message
+	event.TraceID = lowerID(e.TraceID)
+	event.SpanID = lowerID(e.SpanID)
+	event.CorrelationID = e.CorrelationID
+	event.Attributes = e.Attributes.ToEventAttributes()
 	if e.Data != nil {
 		event.HasData = true
 		event.EventData.Data = *e.Data
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// The most events accepted in a single batch
+const MaxNewEventBatch = 10000
+
+// One item of a batch, Err is set if it couldn't be decoded or isn't valid
+type NewEventBatchItem struct {
+	Event NewEvent
+	Err   error
+}
+
+// A new event for a channel named by whoever sent it rather than the URL, Err is set if it can't be saved
+type RoutedNewEvent struct {
+	Channel string
+	Event   *NewEvent
+	Err     error
+}
+
+// The outcome of each item in a batch, in the order they were sent
+type BatchItemResult struct {
+	Index int     `json:"index"`
+	Event *Event  `json:"event,omitempty"`
+	Error *string `json:"error,omitempty"`
+}
+
+// Spooled events were accepted but are waiting for the database to be available
+type BatchResult struct {
+	Created int                `json:"created"`
+	Spooled int                `json:"spooled"`
+	Failed  int                `json:"failed"`
+	Results []*BatchItemResult `json:"results"`
+}
+
+// Check a new event has everything it needs
+func (e *NewEvent) Validate() error {
+	if len(strings.TrimSpace(e.Source)) == 0 {
+		return fmt.Errorf("source is required")
+	}
+	if len(e.Level) == 0 {
+		return fmt.Errorf("level is required")
+	}
+	if !e.Level.IsValid() {
+		return fmt.Errorf("%s is not a valid EventLevel", e.Level)
+	}
+	if len(strings.TrimSpace(e.Message)) == 0 {
+		return fmt.Errorf("message is required")
+	}
+	if _, err := e.GetTimestamp(); err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	if err := e.validateTrace(); err != nil {
+		return err
+	}
+	return e.Attributes.Validate()
+}
+
+// Decode a batch of new events, using the content type to pick the format...
+//
+// JSON is an array of events, NDJSON is one event per line, YAML is a sequence of events, TOML is an
+// [[events]] array of tables and XML is any root element containing one element per event. Without a
+// recognised content type each format is tried in turn. Items which don't decode are returned with an
+// error rather than failing the whole batch.
+func DecodeNewEvents(data []byte, contentType string) ([]*NewEventBatchItem, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		// Some shippers send NDJSON as plain JSON...
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '[' {
+			return decodeNDJSON(data)
+		}
+		return decodeJSONArray(data)
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return decodeNDJSON(data)
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return decodeYAMLSequence(data)
+	case "application/toml":
+		return decodeTOMLTables(data)
+	case "application/xml", "text/xml":
+		return decodeXMLElements(data)
+	}
+
+	for _, decode := range []func([]byte) ([]*NewEventBatchItem, error){decodeJSONArray, decodeNDJSON, decodeXMLElements, decodeYAMLSequence, decodeTOMLTables} {
+		if items, err := decode(data); err == nil && len(items) > 0 {
+			return items, nil
+		}
+	}
+	return nil, fmt.Errorf("unable to decode a batch of events")
+}
+
+func newBatchItem(raw []byte, decode func(*NewEvent, []byte) error) *NewEventBatchItem {
+	item := &NewEventBatchItem{}
+	item.Err = decode(&item.Event, raw)
+	if item.Err == nil {
+		item.Err = item.Event.Validate()
+	}
+	return item
+}
+
+func checkBatchSize(n int) error {
+	if n > MaxNewEventBatch {
+		return fmt.Errorf("a batch cannot have more than %d events", MaxNewEventBatch)
+	}
+	return nil
+}
+
+func decodeJSONArray(data []byte) ([]*NewEventBatchItem, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(len(raw)); err != nil {
+		return nil, err
+	}
+	items := make([]*NewEventBatchItem, len(raw))
+	for i, r := range raw {
+		items[i] = newBatchItem(r, (*NewEvent).FromJSON)
+	}
+	return items, nil
+}
+
+func decodeNDJSON(data []byte) ([]*NewEventBatchItem, error) {
+	var items []*NewEventBatchItem
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := checkBatchSize(len(items) + 1); err != nil {
+			return nil, err
+		}
+		items = append(items, newBatchItem(line, (*NewEvent).FromJSON))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func decodeYAMLSequence(data []byte) ([]*NewEventBatchItem, error) {
+	var nodes []yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(len(nodes)); err != nil {
+		return nil, err
+	}
+	items := make([]*NewEventBatchItem, len(nodes))
+	for i := range nodes {
+		raw, err := yaml.Marshal(&nodes[i])
+		if err != nil {
+			items[i] = &NewEventBatchItem{Err: err}
+			continue
+		}
+		items[i] = newBatchItem(raw, (*NewEvent).FromYAML)
+	}
+	return items, nil
+}
+
+func decodeTOMLTables(data []byte) ([]*NewEventBatchItem, error) {
+	var doc struct {
+		Events []map[string]interface{} `toml:"events"`
+	}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(len(doc.Events)); err != nil {
+		return nil, err
+	}
+	items := make([]*NewEventBatchItem, len(doc.Events))
+	for i, table := range doc.Events {
+		raw, err := toml.Marshal(table)
+		if err != nil {
+			items[i] = &NewEventBatchItem{Err: err}
+			continue
+		}
+		items[i] = newBatchItem(raw, (*NewEvent).FromTOML)
+	}
+	return items, nil
+}
+
+func decodeXMLElements(data []byte) ([]*NewEventBatchItem, error) {
+	var doc struct {
+		Events []struct {
+			XMLName xml.Name
+			Inner   []byte `xml:",innerxml"`
+		} `xml:",any"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := checkBatchSize(len(doc.Events)); err != nil {
+		return nil, err
+	}
+	items := make([]*NewEventBatchItem, len(doc.Events))
+	for i, e := range doc.Events {
+		raw := append(append([]byte("<event>"), e.Inner...), []byte("</event>")...)
+		items[i] = newBatchItem(raw, (*NewEvent).FromXML)
+	}
+	return items, nil
+}
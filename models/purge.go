@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type ChannelPurge struct {
+	ChannelID uint    `json:"channelId"`
+	Channel   string  `json:"channel"`
+	Events    int64   `json:"events"`
+	EventData int64   `json:"eventData"`
+	Complete  bool    `json:"complete"`
+	Error     *string `json:"error"`
+}
+
+type PurgeReport struct {
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	Events     int64           `json:"events"`
+	EventData  int64           `json:"eventData"`
+	Channels   []*ChannelPurge `json:"channels"`
+}
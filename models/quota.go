@@ -0,0 +1,55 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// What a channel currently holds, counted against its quota
+type ChannelUsage struct {
+	Events    int64 `json:"events"`
+	DataBytes int64 `json:"dataBytes"`
+}
+
+// What happens to a new event which would take a channel over its quota
+type QuotaMode string
+
+const (
+	QuotaModeRejectNew  QuotaMode = "reject_new"
+	QuotaModeDropOldest QuotaMode = "drop_oldest"
+)
+
+var AllQuotaMode = []QuotaMode{
+	QuotaModeRejectNew,
+	QuotaModeDropOldest,
+}
+
+func (e QuotaMode) IsValid() bool {
+	switch e {
+	case QuotaModeRejectNew, QuotaModeDropOldest:
+		return true
+	}
+	return false
+}
+
+func (e QuotaMode) String() string {
+	return string(e)
+}
+
+func (e *QuotaMode) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = QuotaMode(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid QuotaMode", str)
+	}
+	return nil
+}
+
+func (e QuotaMode) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
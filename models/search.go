@@ -0,0 +1,7 @@
+package models
+
+type SearchResult struct {
+	Event   *Event  `json:"event"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type LevelCount struct {
+	Level EventLevel `json:"level"`
+	Count int64      `json:"count"`
+}
+
+type SourceCount struct {
+	Source string `json:"source"`
+	Count  int64  `json:"count"`
+}
+
+type EventStats struct {
+	Total    int64          `json:"total"`
+	ByLevel  []*LevelCount  `json:"byLevel"`
+	BySource []*SourceCount `json:"bySource"`
+}
+
+type HistogramBucket struct {
+	Start  time.Time     `json:"start"`
+	Count  int64         `json:"count"`
+	Levels []*LevelCount `json:"levels"`
+}
+
+type TimeBucket string
+
+const (
+	TimeBucketMinute TimeBucket = "minute"
+	TimeBucketHour   TimeBucket = "hour"
+	TimeBucketDay    TimeBucket = "day"
+)
+
+var AllTimeBucket = []TimeBucket{
+	TimeBucketMinute,
+	TimeBucketHour,
+	TimeBucketDay,
+}
+
+// Start of the bucket containing t, in the given location so days follow local midnight
+func (e TimeBucket) Truncate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	switch e {
+	case TimeBucketMinute:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	case TimeBucketHour:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// Start of the bucket after the one starting at start
+func (e TimeBucket) Next(start time.Time) time.Time {
+	switch e {
+	case TimeBucketMinute:
+		return start.Add(time.Minute)
+	case TimeBucketHour:
+		return start.Add(time.Hour)
+	}
+	// Days aren't always 24 hours long...
+	return start.AddDate(0, 0, 1)
+}
+
+func (e TimeBucket) IsValid() bool {
+	switch e {
+	case TimeBucketMinute, TimeBucketHour, TimeBucketDay:
+		return true
+	}
+	return false
+}
+
+func (e TimeBucket) String() string {
+	return string(e)
+}
+
+func (e *TimeBucket) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = TimeBucket(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid TimeBucket", str)
+	}
+	return nil
+}
+
+func (e TimeBucket) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
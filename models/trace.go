@@ -0,0 +1,141 @@
+package models
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Trace context follows W3C Trace Context (https://www.w3.org/TR/trace-context/), correlation IDs are
+// whatever the sender uses to tie its own events together
+const (
+	TraceparentHeader = "traceparent"
+	TraceIDLength     = 32
+	SpanIDLength      = 16
+	MaxCorrelationID  = 128
+)
+
+// Trace context given alongside an event rather than in it, blank if not given
+type TraceContext struct {
+	TraceID       string
+	SpanID        string
+	CorrelationID string
+}
+
+// Parse a traceparent header, version-trace_id-parent_id-flags (00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01)...
+//
+// Later versions can add fields after the flags, so anything after them is ignored unless it's version 00.
+func ParseTraceparent(value string) (traceID string, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || !isHex(parts[0]) || parts[0] == "ff" || (parts[0] == "00" && len(parts) > 4) {
+		return "", "", false
+	}
+	if !ValidTraceID(parts[1]) || !ValidSpanID(parts[2]) || len(parts[3]) != 2 || !isHex(parts[3]) {
+		return "", "", false
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
+
+// Trace IDs are 32 hex characters and can't be all zeros
+func ValidTraceID(id string) bool {
+	return len(id) == TraceIDLength && isHex(id) && strings.Trim(id, "0") != ""
+}
+
+// Span IDs are 16 hex characters and can't be all zeros
+func ValidSpanID(id string) bool {
+	return len(id) == SpanIDLength && isHex(id) && strings.Trim(id, "0") != ""
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Trace context from a traceparent header
+func HeaderTraceContext(h http.Header) TraceContext {
+	var t TraceContext
+	t.TraceID, t.SpanID, _ = ParseTraceparent(h.Get(TraceparentHeader))
+	return t
+}
+
+// Trace context from trace_id, span_id, correlation_id and traceparent parameters, which are removed from
+// the parameters. MQTT 3.1.1 has no user properties, so this is how MQTT clients give it.
+func ParameterTraceContext(params url.Values) TraceContext {
+	var t TraceContext
+	var traceparent string
+	for name, values := range params {
+		if len(values) == 0 {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "trace_id":
+			t.TraceID = values[0]
+		case "span_id":
+			t.SpanID = values[0]
+		case "correlation_id":
+			t.CorrelationID = values[0]
+		case TraceparentHeader:
+			traceparent = values[0]
+		default:
+			continue
+		}
+		delete(params, name)
+	}
+
+	// Explicit IDs win over a traceparent...
+	if len(t.TraceID) == 0 {
+		t.TraceID, t.SpanID, _ = ParseTraceparent(traceparent)
+	}
+	return t
+}
+
+// Fill in any trace context the event doesn't already have...
+//
+// A span ID is only taken along with its trace, so an event's own trace is never given another's span.
+func (e *NewEvent) SetTraceContext(t TraceContext) {
+	if e.TraceID == nil && len(t.TraceID) > 0 {
+		traceID := t.TraceID
+		e.TraceID = &traceID
+		if e.SpanID == nil && len(t.SpanID) > 0 {
+			spanID := t.SpanID
+			e.SpanID = &spanID
+		}
+	}
+	if e.CorrelationID == nil && len(t.CorrelationID) > 0 {
+		correlationID := t.CorrelationID
+		e.CorrelationID = &correlationID
+	}
+}
+
+// Check the trace context of a new event
+func (e *NewEvent) validateTrace() error {
+	if e.TraceID != nil && !ValidTraceID(*e.TraceID) {
+		return fmt.Errorf("trace_id must be %d hex characters", TraceIDLength)
+	}
+	if e.SpanID != nil {
+		if !ValidSpanID(*e.SpanID) {
+			return fmt.Errorf("span_id must be %d hex characters", SpanIDLength)
+		}
+		if e.TraceID == nil {
+			return fmt.Errorf("span_id needs a trace_id")
+		}
+	}
+	if e.CorrelationID != nil && len(*e.CorrelationID) > MaxCorrelationID {
+		return fmt.Errorf("correlation_id is longer than %d characters", MaxCorrelationID)
+	}
+	return nil
+}
+
+// Trace and span IDs are kept in lower case, so a trace can be found whatever case it was sent in
+func lowerID(id *string) *string {
+	if id == nil {
+		return nil
+	}
+	lower := strings.ToLower(*id)
+	return &lower
+}
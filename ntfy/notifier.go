@@ -0,0 +1,245 @@
+package ntfy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/telemetry"
+	"gorm.io/gorm"
+)
+
+// Notifier forwards stored events to an ntfy server...
+//
+// Events are queued and sent by a fixed number of workers, so a slow or unreachable server (which each
+// notification can spend Retries backoffs waiting on) ties up at most Workers goroutines. Once QueueSize
+// notifications are waiting new ones are dropped and counted rather than piling up.
+type Notifier struct {
+	Endpoint  string
+	Retries   uint
+	Backoff   time.Duration
+	Client    *http.Client
+	QueueSize int
+	Workers   int
+	config    *configuration.Config
+	tx        *gorm.DB
+	queue     chan notification
+
+	// Whether new notifications are still accepted
+	mu      sync.Mutex
+	closed  bool
+	workers sync.WaitGroup
+}
+
+type notification struct {
+	channel *models.Channel
+	event   *models.Event
+}
+
+func NewNotifier(config *configuration.Config, tx *gorm.DB) (*Notifier, error) {
+	timeout, err := config.GetNtfyTimeout()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ntfy timeout: %w", err)
+	}
+	backoff, err := config.GetNtfyBackoff()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ntfy backoff: %w", err)
+	}
+	if config.Ntfy.QueueSize < 1 {
+		return nil, fmt.Errorf("ntfy queue size must be at least 1")
+	}
+	if config.Ntfy.Workers < 1 {
+		return nil, fmt.Errorf("ntfy workers must be at least 1")
+	}
+	n := &Notifier{
+		Endpoint:  config.Ntfy.Endpoint,
+		Retries:   config.Ntfy.Retries,
+		Backoff:   backoff,
+		Client:    &http.Client{Timeout: timeout},
+		QueueSize: int(config.Ntfy.QueueSize),
+		Workers:   int(config.Ntfy.Workers),
+		config:    config,
+		tx:        tx,
+		queue:     make(chan notification, config.Ntfy.QueueSize),
+	}
+	telemetry.NtfyQueued.Set(func() float64 { return float64(len(n.queue)) })
+	return n, nil
+}
+
+// Start the workers
+func (n *Notifier) Start() {
+	for i := 0; i < n.Workers; i++ {
+		n.workers.Add(1)
+		go n.worker()
+	}
+}
+
+// Queue an event to be sent to the channel's ntfy topic, dropping it if the queue is full
+func (n *Notifier) Queue(channel *models.Channel, event *models.Event) {
+	if !n.Enabled(channel) {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		telemetry.NtfyNotifications.WithLabelValues(telemetry.ResultDropped).Inc()
+		return
+	}
+	select {
+	case n.queue <- notification{channel: channel, event: event}:
+	default:
+		telemetry.NtfyNotifications.WithLabelValues(telemetry.ResultDropped).Inc()
+		log.Println("ntfy queue is full, dropped notification for channel '" + channel.Name + "'")
+	}
+}
+
+// Stop taking notifications and wait for the queued ones to be sent
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d ntfy notifications not sent: %w", len(n.queue), ctx.Err())
+	}
+}
+
+func (n *Notifier) worker() {
+	defer n.workers.Done()
+	for q := range n.queue {
+		if err := n.Notify(q.channel, q.event); err != nil {
+			telemetry.NtfyNotifications.WithLabelValues(telemetry.ResultFailed).Inc()
+			log.Println("Unable to send event to ntfy for channel '"+q.channel.Name+"'", err)
+			continue
+		}
+		telemetry.NtfyNotifications.WithLabelValues(telemetry.ResultSent).Inc()
+	}
+}
+
+// Should events for the channel be sent to ntfy?
+func (n *Notifier) Enabled(channel *models.Channel) bool {
+	return n.config.Ntfy.Enabled && len(n.Endpoint) > 0 && channel.Ntfy && channel.NtfyTopic != nil
+}
+
+// Send an event to the channels ntfy topic, retrying with an exponential backoff
+func (n *Notifier) Notify(channel *models.Channel, event *models.Event) error {
+	if !n.Enabled(channel) {
+		return nil
+	}
+
+	var dataURL *string
+	if event.HasData {
+		u, err := event.GetDataURL(n.config, n.tx)
+		if err != nil {
+			return err
+		}
+		dataURL = u
+	}
+
+	target := strings.TrimRight(n.Endpoint, "/") + "/" + url.PathEscape(*channel.NtfyTopic)
+	backoff := n.Backoff
+	var err error
+	for attempt := uint(0); attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = n.send(target, event, dataURL)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up on ntfy after %d attempts: %w", n.Retries+1, err)
+}
+
+// Make a single attempt at sending the event, returns whether a failure is worth retrying
+func (n *Notifier) send(target string, event *models.Event, dataURL *string) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, target, strings.NewReader(event.Message))
+	if err != nil {
+		return false, err
+	}
+	title := event.Source
+	if event.Title != nil {
+		title = *event.Title
+	}
+	req.Header.Set("Title", title)
+	req.Header.Set("Priority", strconv.Itoa(Priority(event.Level)))
+	req.Header.Set("Tags", strings.Join(Tags(event), ","))
+	if dataURL != nil {
+		req.Header.Set("Click", *dataURL)
+		req.Header.Set("Attach", *dataURL)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("ntfy returned %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Map an event level onto an ntfy priority (1 = min, 5 = max)
+func Priority(level models.EventLevel) int {
+	switch level {
+	case models.EventLevelDebug:
+		return 1
+	case models.EventLevelInfo:
+		return 2
+	case models.EventLevelWarning:
+		return 3
+	case models.EventLevelError:
+		return 4
+	case models.EventLevelFatal:
+		return 5
+	}
+	return 3
+}
+
+// Tags for the notification, the first is an emoji shortcode for the level
+func Tags(event *models.Event) []string {
+	var emoji string
+	switch event.Level {
+	case models.EventLevelDebug:
+		emoji = "mag"
+	case models.EventLevelInfo:
+		emoji = "information_source"
+	case models.EventLevelWarning:
+		emoji = "warning"
+	case models.EventLevelError:
+		emoji = "x"
+	case models.EventLevelFatal:
+		emoji = "rotating_light"
+	default:
+		emoji = "grey_question"
+	}
+	// ntfy separates tags with commas...
+	return []string{emoji, event.Level.String(), strings.ReplaceAll(event.Source, ",", " ")}
+}
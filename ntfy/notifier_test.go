@@ -0,0 +1,213 @@
+package ntfy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// A stand in for an ntfy server, replying with each status in turn then 200
+type stubServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	times    []time.Time
+	bodies   []string
+}
+
+func newStubServer(t *testing.T, statuses ...int) *stubServer {
+	s := &stubServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, r)
+		s.times = append(s.times, time.Now())
+		s.bodies = append(s.bodies, string(body))
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestNotifier(t *testing.T, endpoint string, retries uint, backoff time.Duration) *Notifier {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Channel{}); err != nil {
+		t.Fatal(err)
+	}
+	config := &configuration.Config{}
+	config.Server.URL = "https://loggo.example.com"
+	config.Ntfy.Enabled = true
+	return &Notifier{
+		Endpoint: endpoint,
+		Retries:  retries,
+		Backoff:  backoff,
+		Client:   &http.Client{Timeout: time.Second},
+		Workers:  1,
+		config:   config,
+		tx:       db,
+		queue:    make(chan notification, 2),
+	}
+}
+
+func testChannel() *models.Channel {
+	topic := "alerts"
+	return &models.Channel{ID: 1, Name: "app", Ntfy: true, NtfyTopic: &topic}
+}
+
+func testEvent() *models.Event {
+	return &models.Event{ID: 7, ChannelID: 1, Source: "web", Level: models.EventLevelError, Message: "it broke"}
+}
+
+func TestNotifyRetriesServerErrors(t *testing.T) {
+	s := newStubServer(t, http.StatusInternalServerError, http.StatusBadGateway)
+	n := newTestNotifier(t, s.URL, 3, time.Millisecond)
+
+	if err := n.Notify(testChannel(), testEvent()); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %v", err)
+	}
+	if len(s.requests) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(s.requests))
+	}
+	if s.requests[0].URL.Path != "/alerts" {
+		t.Errorf("expected the channel's topic, got %s", s.requests[0].URL.Path)
+	}
+}
+
+func TestNotifyGivesUpAfterRetries(t *testing.T) {
+	s := newStubServer(t, 500, 500, 500, 500, 500)
+	n := newTestNotifier(t, s.URL, 2, time.Millisecond)
+
+	if err := n.Notify(testChannel(), testEvent()); err == nil {
+		t.Fatal("expected an error once the retries ran out")
+	}
+	if len(s.requests) != 3 {
+		t.Fatalf("expected 1 attempt and 2 retries, got %d attempts", len(s.requests))
+	}
+}
+
+func TestNotifyDoesNotRetryClientErrors(t *testing.T) {
+	s := newStubServer(t, http.StatusBadRequest)
+	n := newTestNotifier(t, s.URL, 3, time.Millisecond)
+
+	if err := n.Notify(testChannel(), testEvent()); err == nil {
+		t.Fatal("expected an error for a 400")
+	}
+	if len(s.requests) != 1 {
+		t.Fatalf("expected a single attempt, got %d", len(s.requests))
+	}
+}
+
+func TestNotifyBacksOffExponentially(t *testing.T) {
+	backoff := 40 * time.Millisecond
+	s := newStubServer(t, 503, 503)
+	n := newTestNotifier(t, s.URL, 2, backoff)
+
+	if err := n.Notify(testChannel(), testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.times) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(s.times))
+	}
+	for i, want := range []time.Duration{backoff, 2 * backoff} {
+		if got := s.times[i+1].Sub(s.times[i]); got < want {
+			t.Errorf("retry %d came after %s, expected at least %s", i+1, got, want)
+		}
+	}
+}
+
+func TestNotifyHeaders(t *testing.T) {
+	s := newStubServer(t)
+	n := newTestNotifier(t, s.URL, 0, time.Millisecond)
+	channel := testChannel()
+	if err := n.tx.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	title := "Checkout failed"
+	event := testEvent()
+	event.Title = &title
+	event.HasData = true
+	if err := n.Notify(channel, event); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(s.requests))
+	}
+
+	h := s.requests[0].Header
+	for name, want := range map[string]string{
+		"Title":    "Checkout failed",
+		"Priority": "4",
+		"Tags":     "x,error,web",
+		"Click":    "https://loggo.example.com/channel/app/event/7/data/",
+	} {
+		if got := h.Get(name); got != want {
+			t.Errorf("%s header is '%s', expected '%s'", name, got, want)
+		}
+	}
+	if s.bodies[0] != "it broke" {
+		t.Errorf("expected the message as the body, got '%s'", s.bodies[0])
+	}
+}
+
+func TestNotifyTitleDefaultsToSource(t *testing.T) {
+	s := newStubServer(t)
+	n := newTestNotifier(t, s.URL, 0, time.Millisecond)
+
+	if err := n.Notify(testChannel(), testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	h := s.requests[0].Header
+	if got := h.Get("Title"); got != "web" {
+		t.Errorf("expected the source as the title, got '%s'", got)
+	}
+	if got := h.Get("Click"); got != "" {
+		t.Errorf("expected no click URL without data, got '%s'", got)
+	}
+}
+
+func TestQueueDropsWhenFull(t *testing.T) {
+	s := newStubServer(t)
+	n := newTestNotifier(t, s.URL, 0, time.Millisecond)
+
+	// Nothing is sending yet, so only the first two fit...
+	for i := 0; i < 3; i++ {
+		n.Queue(testChannel(), testEvent())
+	}
+	if len(n.queue) != 2 {
+		t.Fatalf("expected 2 queued notifications, got %d", len(n.queue))
+	}
+
+	n.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := n.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.requests) != 2 {
+		t.Fatalf("expected the queued notifications to be sent before closing, got %d requests", len(s.requests))
+	}
+
+	n.Queue(testChannel(), testEvent())
+	if len(n.queue) != 0 {
+		t.Error("expected notifications to be dropped once closed")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaigoh/loggo/otlp"
+	"github.com/kaigoh/loggo/telemetry"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+)
+
+// Receive OpenTelemetry logs over OTLP/HTTP...
+//
+// Records go to the channel named by their resource, the Loggo-Channel header, or the configured default.
+// Records which can't be saved are reported back as a partial success.
+func otlpLogs(c *gin.Context) {
+	data, err := readPushBody(c, telemetry.TransportOTLP)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	req, err := otlp.Decode(data, c.ContentType())
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	fallback := c.GetHeader(otlp.ChannelHeader)
+	if len(fallback) == 0 {
+		fallback = config.OTLP.DefaultChannel
+	}
+
+	records := otlp.Records(req, fallback)
+	if _, err := saveRouted(c.Request.Context(), telemetry.TransportOTLP, records); err != nil {
+		c.AbortWithError(pushStatus(err), err)
+		return
+	}
+
+	// Report anything which wasn't saved, once for each reason...
+	resp := &collogs.ExportLogsServiceResponse{}
+	seen := map[string]bool{}
+	var reasons []string
+	for _, record := range records {
+		if record.Err == nil {
+			continue
+		}
+		if resp.PartialSuccess == nil {
+			resp.PartialSuccess = &collogs.ExportLogsPartialSuccess{}
+		}
+		resp.PartialSuccess.RejectedLogRecords++
+		if reason := record.Err.Error(); !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	if resp.PartialSuccess != nil {
+		resp.PartialSuccess.ErrorMessage = strings.Join(reasons, "; ")
+	}
+
+	out, contentType, err := otlp.Encode(resp, c.ContentType())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	c.Data(http.StatusOK, contentType, out)
+}
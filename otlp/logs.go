@@ -0,0 +1,240 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/kaigoh/loggo/models"
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	common "go.opentelemetry.io/proto/otlp/common/v1"
+	logs "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// A resource attribute naming the channel for its logs, which beats the header
+const ChannelAttribute = "loggo.channel"
+
+// A header naming the channel for logs which don't have the resource attribute
+const ChannelHeader = "Loggo-Channel"
+
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// Whether a request is JSON rather than protobuf, which is the default
+func IsJSON(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == ContentTypeJSON
+}
+
+// Decode an export request, as protobuf or JSON depending on the content type
+func Decode(body []byte, contentType string) (*collogs.ExportLogsServiceRequest, error) {
+	req := &collogs.ExportLogsServiceRequest{}
+	if !IsJSON(contentType) {
+		if err := proto.Unmarshal(body, req); err != nil {
+			return nil, fmt.Errorf("invalid OTLP protobuf: %w", err)
+		}
+		return req, nil
+	}
+
+	// OTLP JSON has trace and span IDs in hex, where protobuf JSON expects base64...
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OTLP JSON: %w", err)
+	}
+	fixIDs(doc)
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, req); err != nil {
+		return nil, fmt.Errorf("invalid OTLP JSON: %w", err)
+	}
+	return req, nil
+}
+
+// Encode a response the same way as the request
+func Encode(resp *collogs.ExportLogsServiceResponse, contentType string) ([]byte, string, error) {
+	if IsJSON(contentType) {
+		out, err := protojson.Marshal(resp)
+		return out, ContentTypeJSON, err
+	}
+	out, err := proto.Marshal(resp)
+	return out, ContentTypeProtobuf, err
+}
+
+func fixIDs(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			switch k {
+			case "traceId", "trace_id", "spanId", "span_id":
+				if s, ok := child.(string); ok {
+					if b, err := hex.DecodeString(s); err == nil {
+						v[k] = base64.StdEncoding.EncodeToString(b)
+					}
+				}
+			default:
+				fixIDs(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			fixIDs(child)
+		}
+	}
+}
+
+// Convert every log record in a request, in order...
+//
+// The channel comes from the loggo.channel resource attribute, or fallback if there isn't one. The
+// source is the service.name resource attribute and the record's attributes, on top of the resource's,
+// are kept as the event's JSON data.
+func Records(req *collogs.ExportLogsServiceRequest, fallback string) []*models.RoutedNewEvent {
+	var records []*models.RoutedNewEvent
+	for _, rl := range req.GetResourceLogs() {
+		resource := attributes(rl.GetResource().GetAttributes())
+		channel := fallback
+		if name, ok := resource[ChannelAttribute].(string); ok && len(strings.TrimSpace(name)) > 0 {
+			channel = name
+		}
+		delete(resource, ChannelAttribute)
+		service, _ := resource["service.name"].(string)
+
+		for _, sl := range rl.GetScopeLogs() {
+			source := service
+			if len(source) == 0 {
+				source = sl.GetScope().GetName()
+			}
+			if len(source) == 0 {
+				source = "otlp"
+			}
+			for _, lr := range sl.GetLogRecords() {
+				record := &models.RoutedNewEvent{Channel: channel}
+				record.Event, record.Err = newEvent(lr, source, resource)
+				if record.Err == nil && len(channel) == 0 {
+					record.Err = fmt.Errorf("no channel, set the %s resource attribute or %s header", ChannelAttribute, ChannelHeader)
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	return records
+}
+
+func newEvent(lr *logs.LogRecord, source string, resource map[string]interface{}) (*models.NewEvent, error) {
+	n := &models.NewEvent{
+		Source:  source,
+		Level:   Level(lr.GetSeverityNumber(), lr.GetSeverityText()),
+		Message: bodyString(lr.GetBody()),
+	}
+
+	ts := lr.GetTimeUnixNano()
+	if ts == 0 {
+		ts = lr.GetObservedTimeUnixNano()
+	}
+	if ts > 0 {
+		t := time.Unix(0, int64(ts)).UTC().Format(time.RFC3339Nano)
+		n.Timestamp = &t
+	}
+
+	// Records logged inside a span carry its trace context...
+	if traceID := hex.EncodeToString(lr.GetTraceId()); models.ValidTraceID(traceID) {
+		n.TraceID = &traceID
+		if spanID := hex.EncodeToString(lr.GetSpanId()); models.ValidSpanID(spanID) {
+			n.SpanID = &spanID
+		}
+	}
+
+	data := map[string]interface{}{}
+	for k, v := range resource {
+		data[k] = v
+	}
+	for k, v := range attributes(lr.GetAttributes()) {
+		data[k] = v
+	}
+	if len(data) > 0 {
+		out, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		n.Data = &out
+	}
+	return n, n.Validate()
+}
+
+// The event level for a severity, using the text when the number isn't set...
+//
+// Trace is debug, anything unrecognised is info.
+func Level(number logs.SeverityNumber, text string) models.EventLevel {
+	switch {
+	case number >= logs.SeverityNumber_SEVERITY_NUMBER_FATAL:
+		return models.EventLevelFatal
+	case number >= logs.SeverityNumber_SEVERITY_NUMBER_ERROR:
+		return models.EventLevelError
+	case number >= logs.SeverityNumber_SEVERITY_NUMBER_WARN:
+		return models.EventLevelWarning
+	case number >= logs.SeverityNumber_SEVERITY_NUMBER_INFO:
+		return models.EventLevelInfo
+	case number >= logs.SeverityNumber_SEVERITY_NUMBER_TRACE:
+		return models.EventLevelDebug
+	}
+	if level, ok := models.ParseEventLevel(text); ok {
+		return level
+	}
+	return models.EventLevelInfo
+}
+
+// The body as a message, anything other than a string is written out as JSON
+func bodyString(body *common.AnyValue) string {
+	if body == nil {
+		return ""
+	}
+	if s, ok := body.GetValue().(*common.AnyValue_StringValue); ok {
+		return s.StringValue
+	}
+	out, err := json.Marshal(value(body))
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func attributes(kvs []*common.KeyValue) map[string]interface{} {
+	m := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		m[kv.GetKey()] = value(kv.GetValue())
+	}
+	return m
+}
+
+func value(v *common.AnyValue) interface{} {
+	switch v := v.GetValue().(type) {
+	case *common.AnyValue_StringValue:
+		return v.StringValue
+	case *common.AnyValue_BoolValue:
+		return v.BoolValue
+	case *common.AnyValue_IntValue:
+		return v.IntValue
+	case *common.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *common.AnyValue_BytesValue:
+		return v.BytesValue
+	case *common.AnyValue_ArrayValue:
+		values := make([]interface{}, len(v.ArrayValue.GetValues()))
+		for i, child := range v.ArrayValue.GetValues() {
+			values[i] = value(child)
+		}
+		return values
+	case *common.AnyValue_KvlistValue:
+		return attributes(v.KvlistValue.GetValues())
+	}
+	return nil
+}
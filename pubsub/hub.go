@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kaigoh/loggo/models"
+)
+
+// How many events a slow subscriber can fall behind before events are dropped for it
+const subscriberBuffer = 64
+
+type Filter struct {
+	ChannelID *uint
+	MinLevel  *models.EventLevel
+	Source    *string
+}
+
+func (f *Filter) Matches(event *models.Event) bool {
+	if f.ChannelID != nil && *f.ChannelID != event.ChannelID {
+		return false
+	}
+	if f.MinLevel != nil && event.Level.Severity() < f.MinLevel.Severity() {
+		return false
+	}
+	if f.Source != nil && *f.Source != event.Source {
+		return false
+	}
+	return true
+}
+
+type subscriber struct {
+	filter Filter
+	events chan *models.Event
+}
+
+// Hub fans out newly stored events to everyone who is subscribed to them
+type Hub struct {
+	mu          sync.RWMutex
+	next        uint64
+	subscribers map[uint64]*subscriber
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: map[uint64]*subscriber{},
+	}
+}
+
+// Subscribe to events matching the filter, the returned channel is closed once the context is done
+func (h *Hub) Subscribe(ctx context.Context, filter Filter) <-chan *models.Event {
+	s := &subscriber{
+		filter: filter,
+		events: make(chan *models.Event, subscriberBuffer),
+	}
+
+	h.mu.Lock()
+	id := h.next
+	h.next++
+	h.subscribers[id] = s
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subscribers, id)
+		close(s.events)
+		h.mu.Unlock()
+	}()
+
+	return s.events
+}
+
+// Publish an event to all matching subscribers, never blocking on a slow one...
+func (h *Hub) Publish(event *models.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, s := range h.subscribers {
+		if !s.filter.Matches(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// Number of active subscriptions
+func (h *Hub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
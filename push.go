@@ -0,0 +1,247 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaigoh/loggo/auth"
+	"github.com/kaigoh/loggo/elastic"
+	"github.com/kaigoh/loggo/ingest"
+	"github.com/kaigoh/loggo/loki"
+	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/quota"
+	"github.com/kaigoh/loggo/telemetry"
+)
+
+// A channel named in a push doesn't exist
+var errUnknownChannel = errors.New("not found")
+
+// Read the body of a push from another logging tool's client, which is often gzipped
+func readPushBody(c *gin.Context, transport string) ([]byte, error) {
+	var body io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBytes)
+	if strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = io.LimitReader(zr, maxBatchBytes)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	telemetry.PayloadSize.WithLabelValues(transport).Observe(float64(len(data)))
+	return data, nil
+}
+
+// Receive a Loki push, as sent by Promtail and anything else with a Loki client
+func lokiPush(c *gin.Context) {
+	data, err := readPushBody(c, telemetry.TransportLoki)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	streams, err := loki.Decode(data, c.ContentType())
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	events := loki.Events(&config, streams)
+	if _, err := saveRouted(c.Request.Context(), telemetry.TransportLoki, events); err != nil {
+		c.AbortWithError(pushStatus(err), err)
+		return
+	}
+
+	// Loki refuses a push with any bad lines, the good ones here have been saved but say which weren't...
+	var reasons []string
+	failed := 0
+	for _, e := range events {
+		if e.Err != nil {
+			if failed == 0 || reasons[len(reasons)-1] != e.Err.Error() {
+				reasons = append(reasons, e.Err.Error())
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		c.String(http.StatusBadRequest, "%d of %d lines were not saved: %s", failed, len(events), strings.Join(reasons, "; "))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Receive an Elasticsearch bulk request, as sent by Fluent Bit, Vector and the like
+func elasticBulk(c *gin.Context) {
+	start := time.Now()
+	data, err := readPushBody(c, telemetry.TransportElasticsearch)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	items, err := elastic.Decode(data, c.Param("index"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	events := make([]*models.RoutedNewEvent, len(items))
+	for i, item := range items {
+		events[i] = item.Event
+	}
+	saved, err := saveRouted(c.Request.Context(), telemetry.TransportElasticsearch, events)
+	if err != nil {
+		c.AbortWithError(pushStatus(err), err)
+		return
+	}
+
+	resp := &elastic.Response{Items: make([]map[string]*elastic.ItemResult, len(items))}
+	for i, item := range items {
+		result := &elastic.ItemResult{Index: item.Index, ID: item.ID}
+		resp.Items[i] = map[string]*elastic.ItemResult{item.Action: result}
+		if err := item.Event.Err; err != nil {
+			resp.Errors = true
+			result.Status, result.Error = elasticError(err)
+			continue
+		}
+		result.Status = http.StatusCreated
+		result.Result = "created"
+		if saved[i].ID > 0 {
+			result.ID = strconv.FormatUint(uint64(saved[i].ID), 10)
+		}
+	}
+	resp.Took = time.Since(start).Milliseconds()
+	c.JSON(http.StatusOK, resp)
+}
+
+// The status and error Elasticsearch would give for an item which wasn't saved
+func elasticError(err error) (int, *elastic.ItemError) {
+	e := &elastic.ItemError{Type: "mapper_parsing_exception", Reason: err.Error()}
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errUnknownChannel):
+		status, e.Type = http.StatusNotFound, "index_not_found_exception"
+	case errors.Is(err, elastic.ErrUnsupportedAction):
+		e.Type = "illegal_argument_exception"
+	case errors.Is(err, auth.ErrForbidden):
+		status, e.Type = http.StatusForbidden, "security_exception"
+	case errors.Is(err, ingest.ErrQueueFull), errors.Is(err, ingest.ErrClosed):
+		status, e.Type = saveStatus(err), "es_rejected_execution_exception"
+	case errors.Is(err, quota.ErrQuotaExceeded):
+		status, e.Type = saveStatus(err), "cluster_block_exception"
+	}
+	return status, e
+}
+
+// The HTTP status for a push which couldn't be saved at all
+func pushStatus(err error) int {
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return http.StatusUnauthorized
+	}
+	return saveStatus(err)
+}
+
+// Save events to the channels they name, one batch per channel...
+//
+// The saved events are returned in the same order, with a nil event and Err set on the item for any which
+// couldn't be saved. The error is only set when the whole push should be refused: the caller isn't
+// authenticated, the channels couldn't be looked up, or the queue is full (or shutting down) before anything
+// was saved so it is safe to retry.
+func saveRouted(ctx context.Context, transport string, items []*models.RoutedNewEvent) ([]*models.Event, error) {
+	saved := make([]*models.Event, len(items))
+
+	// Group them by channel, keeping them in order...
+	var names []string
+	groups := map[string][]int{}
+	for i, item := range items {
+		if item.Err != nil {
+			continue
+		}
+		if _, ok := groups[item.Channel]; !ok {
+			names = append(names, item.Channel)
+		}
+		groups[item.Channel] = append(groups[item.Channel], i)
+	}
+	fail := func(indexes []int, err error) {
+		for _, i := range indexes {
+			items[i].Err = err
+		}
+	}
+
+	// Look the channels up before saving anything, so if the database is unhappy the whole push can be
+	// refused and sent again without duplicating events...
+	channels := map[string]*models.Channel{}
+	for _, name := range names {
+		channel, err := models.ChannelByName(db, name)
+		if errors.Is(err, models.ErrChannelNotFound) {
+			fail(groups[name], fmt.Errorf("channel '%s' %w", name, errUnknownChannel))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		channels[name] = channel
+	}
+
+	written := 0
+	for _, name := range names {
+		group := groups[name]
+		channel, ok := channels[name]
+		if !ok {
+			continue
+		}
+		if err := auth.Check(ctx, &channel.ID, models.PermissionWrite); err != nil {
+			if errors.Is(err, auth.ErrUnauthenticated) {
+				return nil, err
+			}
+			fail(group, fmt.Errorf("channel '%s': %w", name, err))
+			continue
+		}
+
+		var indexes []int
+		var events []*models.Event
+		for _, i := range group {
+			items[i].Event.ChannelID = channel.ID
+			event, err := items[i].Event.ToEvent()
+			if err != nil {
+				items[i].Err = err
+				continue
+			}
+			indexes = append(indexes, i)
+			events = append(events, &event)
+		}
+		if len(events) == 0 {
+			continue
+		}
+
+		err := saveEvents(ctx, transport, channel, events)
+		if err != nil && !errors.Is(err, ingest.ErrSpooled) {
+			// Nothing's been saved yet so it's safe for the sender to push the lot again...
+			if status := saveStatus(err); written == 0 && (status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable) {
+				return nil, err
+			}
+			fail(indexes, fmt.Errorf("channel '%s': %w", name, err))
+			continue
+		}
+		written += len(events)
+		for n, i := range indexes {
+			saved[i] = events[n]
+		}
+
+		// Spooled events are published once they are replayed...
+		if err == nil {
+			for _, event := range events {
+				publishEvent(transport, channel, event, nil)
+			}
+		}
+	}
+	return saved, nil
+}
@@ -0,0 +1,161 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/kaigoh/loggo/database"
+	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/telemetry"
+	"gorm.io/gorm"
+)
+
+// How many of the oldest events are dropped at a time to make room
+const dropBatchSize = 500
+
+var ErrQuotaExceeded = errors.New("channel quota exceeded")
+
+// Does the channel have any limits set?
+func Limited(channel *models.Channel) bool {
+	return channel.MaxEvents != nil || channel.MaxDataBytes != nil
+}
+
+// Make room for new events in their channel, before they are saved...
+//
+// In reject_new mode ErrQuotaExceeded is returned if the events would take the channel over either limit,
+// in drop_oldest mode the oldest events are deleted until they fit. Events are accepted or rejected as a
+// whole, and an event whose data is bigger than the whole data limit is always rejected. Quotas are checked
+// before the insert rather than locked, so concurrent inserts can briefly take a channel over its limits.
+func Enforce(tx *gorm.DB, channel *models.Channel, events []*models.Event) error {
+	return EnforcePending(tx, channel, nil, events)
+}
+
+// Enforce for events which are to be written together with others already let into the channel (pending),
+// which aren't in the database yet so its usage doesn't include them
+func EnforcePending(tx *gorm.DB, channel *models.Channel, pending []*models.Event, events []*models.Event) error {
+	if !Limited(channel) || len(events) == 0 {
+		return nil
+	}
+
+	count := int64(len(events))
+	size := int64(0)
+	for _, event := range events {
+		if !event.HasData {
+			continue
+		}
+		size += int64(len(event.EventData.Data))
+		if channel.MaxDataBytes != nil && int64(len(event.EventData.Data)) > *channel.MaxDataBytes {
+			telemetry.QuotaEvents.WithLabelValues(channel.Name, telemetry.ResultRejected).Add(float64(count))
+			return fmt.Errorf("%w: event data is bigger than the channel data limit", ErrQuotaExceeded)
+		}
+	}
+	if channel.MaxEvents != nil && count > *channel.MaxEvents {
+		telemetry.QuotaEvents.WithLabelValues(channel.Name, telemetry.ResultRejected).Add(float64(count))
+		return fmt.Errorf("%w: batch has more events than the channel limit", ErrQuotaExceeded)
+	}
+	if channel.MaxDataBytes != nil && size > *channel.MaxDataBytes {
+		telemetry.QuotaEvents.WithLabelValues(channel.Name, telemetry.ResultRejected).Add(float64(count))
+		return fmt.Errorf("%w: batch has more data than the channel limit", ErrQuotaExceeded)
+	}
+
+	usage, err := database.ChannelUsage(tx, channel.ID)
+	if err != nil {
+		return err
+	}
+	for _, event := range pending {
+		usage.Events++
+		if event.HasData {
+			usage.DataBytes += int64(len(event.EventData.Data))
+		}
+	}
+	if fits(channel, usage, count, size) {
+		return nil
+	}
+
+	if channel.QuotaMode != models.QuotaModeDropOldest {
+		telemetry.QuotaEvents.WithLabelValues(channel.Name, telemetry.ResultRejected).Add(float64(count))
+		return ErrQuotaExceeded
+	}
+
+	dropped := int64(0)
+	for !fits(channel, usage, count, size) {
+		events, data, err := dropOldest(tx, channel, usage, count, size)
+		if err != nil {
+			return err
+		}
+		if events == 0 {
+			// Nothing left to drop, the limits must have been set below a single event...
+			telemetry.QuotaEvents.WithLabelValues(channel.Name, telemetry.ResultRejected).Add(float64(count))
+			return ErrQuotaExceeded
+		}
+		usage.Events -= events
+		usage.DataBytes -= data
+		dropped += events
+	}
+	telemetry.QuotaEvents.WithLabelValues(channel.Name, telemetry.ResultDropped).Add(float64(dropped))
+	log.Println("Dropped " + strconv.Itoa(int(dropped)) + " events from channel '" + channel.Name + "' to stay within its quota")
+	return nil
+}
+
+func fits(channel *models.Channel, usage *models.ChannelUsage, count int64, size int64) bool {
+	if channel.MaxEvents != nil && usage.Events+count > *channel.MaxEvents {
+		return false
+	}
+	if channel.MaxDataBytes != nil && usage.DataBytes+size > *channel.MaxDataBytes {
+		return false
+	}
+	return true
+}
+
+// Delete just enough of the oldest events to fit the new ones, up to a batch at a time
+func dropOldest(tx *gorm.DB, channel *models.Channel, usage *models.ChannelUsage, count int64, size int64) (events int64, data int64, err error) {
+	var oldest []struct {
+		ID   uint
+		Size int64
+	}
+	result := tx.Model(&models.Event{}).Select("events.id AS id, COALESCE(event_data.size, 0) AS size").
+		Joins("LEFT JOIN event_data ON event_data.event_id = events.id").
+		Where("events.channel_id = ?", channel.ID).
+		Order("events.timestamp ASC, events.id ASC").Limit(dropBatchSize).Scan(&oldest)
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+
+	// Work out how many need to go...
+	remaining := *usage
+	var ids []uint
+	for _, e := range oldest {
+		if fits(channel, &remaining, count, size) {
+			break
+		}
+		ids = append(ids, e.ID)
+		remaining.Events--
+		remaining.DataBytes -= e.Size
+	}
+	if len(ids) == 0 {
+		return 0, 0, nil
+	}
+
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("event_id IN ?", ids).Delete(&models.EventAttribute{})
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Where("event_id IN ?", ids).Delete(&models.EventData{})
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Where("id IN ?", ids).Delete(&models.Event{})
+		if result.Error != nil {
+			return result.Error
+		}
+		events = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return events, usage.DataBytes - remaining.DataBytes, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/kaigoh/loggo/archive"
+	"github.com/kaigoh/loggo/models"
+)
+
+// loggo restore [-channel name] file...
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	channelName := flags.String("channel", "", "restore into this channel instead of the one the events were archived from")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: loggo restore [-channel name] file...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no archive files given")
+	}
+
+	var channel *models.Channel
+	if len(*channelName) > 0 {
+		var err error
+		channel, err = models.ChannelByName(db, *channelName)
+		if err != nil {
+			return fmt.Errorf("unable to restore into channel '%s': %w", *channelName, err)
+		}
+	}
+
+	failed := 0
+	for _, path := range flags.Args() {
+		report, err := archive.Restore(db, path, channel)
+		if err != nil {
+			log.Println("Unable to restore '"+path+"'", err)
+			failed++
+			continue
+		}
+		log.Printf("Restored %d events from '%s', skipped %d already present\n", report.Events, path, report.Skipped)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d archive files could not be restored", failed, flags.NArg())
+	}
+	return nil
+}
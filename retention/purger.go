@@ -0,0 +1,261 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kaigoh/loggo/archive"
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/telemetry"
+	"gorm.io/gorm"
+)
+
+// Purger deletes events which have outlived their channels TTL for their level
+type Purger struct {
+	BatchSize  int
+	MaxBatches int
+	// Expired events are archived before they are deleted when set
+	Archiver *archive.Archiver
+	interval time.Duration
+	startup  bool
+	config   *configuration.Config
+	tx       *gorm.DB
+
+	// Only one purge runs at a time, whether scheduled or on demand
+	mu sync.Mutex
+}
+
+func NewPurger(config *configuration.Config, tx *gorm.DB) (*Purger, error) {
+	interval, err := config.GetRetentionInterval()
+	if err != nil {
+		return nil, fmt.Errorf("invalid retention interval: %w", err)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("retention interval must be positive")
+	}
+	batchSize := int(config.Retention.BatchSize)
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+	var archiver *archive.Archiver
+	if config.Archive.Enabled {
+		archiver, err = archive.NewArchiver(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive configuration: %w", err)
+		}
+	}
+	return &Purger{
+		Archiver:   archiver,
+		BatchSize:  batchSize,
+		MaxBatches: int(config.Retention.MaxBatches),
+		interval:   interval,
+		startup:    config.Retention.RunOnStartup,
+		config:     config,
+		tx:         tx,
+	}, nil
+}
+
+// Purge on a schedule until the context is done
+func (p *Purger) Run(ctx context.Context) {
+	log.Println("Starting retention purge every " + p.interval.String())
+
+	if p.startup {
+		p.scheduled()
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping retention purge")
+			return
+		case <-ticker.C:
+			p.scheduled()
+		}
+	}
+}
+
+func (p *Purger) scheduled() {
+	log.Println("Running retention purge...")
+	report, err := p.Purge(nil)
+	if err != nil {
+		log.Println("Retention purge failed", err)
+		return
+	}
+	log.Println("Retention purge finished in " + report.FinishedAt.Sub(report.StartedAt).String() + ", purged " + strconv.Itoa(int(report.Events)) + " events")
+}
+
+// Purge expired events from one channel, or every channel if nil
+func (p *Purger) Purge(channelID *uint) (*models.PurgeReport, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	report := &models.PurgeReport{
+		StartedAt: time.Now(),
+		Channels:  []*models.ChannelPurge{},
+	}
+
+	var channels []*models.Channel
+	q := p.tx.Order("name ASC")
+	if channelID != nil {
+		q = q.Where("id = ?", *channelID)
+	}
+	result := q.Find(&channels)
+	if result.Error != nil {
+		telemetry.PurgeRuns.WithLabelValues("error").Inc()
+		return nil, result.Error
+	}
+
+	outcome := "ok"
+	for _, c := range channels {
+		cp := p.purgeChannel(c)
+		report.Events += cp.Events
+		report.EventData += cp.EventData
+		report.Channels = append(report.Channels, cp)
+		if cp.Error != nil {
+			outcome = "error"
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	telemetry.PurgeRuns.WithLabelValues(outcome).Inc()
+	telemetry.PurgedRows.WithLabelValues("events").Add(float64(report.Events))
+	telemetry.PurgedRows.WithLabelValues("event_data").Add(float64(report.EventData))
+	return report, nil
+}
+
+func (p *Purger) purgeChannel(c *models.Channel) *models.ChannelPurge {
+	cp := &models.ChannelPurge{ChannelID: c.ID, Channel: c.Name}
+	fail := func(err error) *models.ChannelPurge {
+		msg := err.Error()
+		cp.Error = &msg
+		return cp
+	}
+
+	// Get the TTL for each level of the channels events, levels sharing a TTL are purged together...
+	var passes []*purgePass
+	byTTL := map[time.Duration]*purgePass{}
+	for _, level := range models.AllEventLevel {
+		ttl, err := c.GetTTL(p.config, level)
+		if err != nil {
+			log.Println("Unable to process " + level.String() + " TTL for channel '" + c.Name + "' - events will NOT be purged!")
+			return fail(err)
+		}
+		if _, ok := byTTL[ttl]; !ok {
+			byTTL[ttl] = &purgePass{ttl: ttl}
+			passes = append(passes, byTTL[ttl])
+		}
+		byTTL[ttl].levels = append(byTTL[ttl].levels, level)
+	}
+
+	// Levels aren't checked on every route in, so anything else gets the channel (or default) TTL...
+	ttl, err := c.GetTTL(p.config, "")
+	if err != nil {
+		log.Println("Unable to process TTL for channel '" + c.Name + "' - events will NOT be purged!")
+		return fail(err)
+	}
+	passes = append(passes, &purgePass{ttl: ttl, levels: models.AllEventLevel, others: true})
+
+	// ...and purge events which have expired, a batch at a time so no single statement gets too big
+	cp.Complete = true
+	batch := 0
+	for _, pass := range passes {
+		window := time.Now().Add(-pass.ttl)
+		for {
+			if p.MaxBatches > 0 && batch >= p.MaxBatches {
+				cp.Complete = false
+				break
+			}
+			batch++
+			events, data, err := p.purgeBatch(c, pass, window)
+			cp.Events += events
+			cp.EventData += data
+			if err != nil {
+				log.Println("Database error pruning events for channel '"+c.Name+"'", err)
+				cp.Complete = false
+				return fail(err)
+			}
+			if events < int64(p.BatchSize) {
+				break
+			}
+		}
+	}
+
+	if cp.Events > 0 {
+		log.Println("Purged " + strconv.Itoa(int(cp.Events)) + " events for channel '" + c.Name + "'")
+	}
+	return cp
+}
+
+// Events of some levels which share a TTL, or when others is set every level but those
+type purgePass struct {
+	ttl    time.Duration
+	levels []models.EventLevel
+	others bool
+}
+
+func (p *Purger) purgeBatch(c *models.Channel, pass *purgePass, window time.Time) (events int64, data int64, err error) {
+	condition := "channel_id = ? AND level IN ? AND timestamp <= ?"
+	if pass.others {
+		condition = "channel_id = ? AND level NOT IN ? AND timestamp <= ?"
+	}
+	var staged *archive.Staged
+	err = p.tx.Transaction(func(tx *gorm.DB) error {
+		var ids []uint
+		result := tx.Model(&models.Event{}).Where(condition, c.ID, pass.levels, window).Order("id ASC").Limit(p.BatchSize).Pluck("id", &ids)
+		if result.Error != nil {
+			return result.Error
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		// If the archive can't be written, the batch is rolled back and nothing is deleted...
+		if p.Archiver != nil {
+			var expired []models.Event
+			result = tx.Preload("EventData").Preload("Attributes").Where("id IN ?", ids).Order("timestamp ASC, id ASC").Find(&expired)
+			if result.Error != nil {
+				return result.Error
+			}
+			s, err := p.Archiver.Stage(c, expired)
+			if err != nil {
+				return err
+			}
+			staged = s
+		}
+		result = tx.Where("event_id IN ?", ids).Delete(&models.EventAttribute{})
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Where("event_id IN ?", ids).Delete(&models.EventData{})
+		if result.Error != nil {
+			return result.Error
+		}
+		data = result.RowsAffected
+		result = tx.Where("id IN ?", ids).Delete(&models.Event{})
+		if result.Error != nil {
+			return result.Error
+		}
+		events = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		if staged != nil {
+			staged.Discard()
+		}
+		return 0, 0, err
+	}
+	// ...and the archive is only added to once the events are gone, so a batch which fails to commit isn't
+	// archived again by the next purge
+	if staged != nil {
+		if err = staged.Commit(); err != nil {
+			return events, data, err
+		}
+	}
+	return events, data, nil
+}
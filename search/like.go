@@ -0,0 +1,64 @@
+package search
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+// How many more candidates than requested results are ranked, as LIKE can't rank in the database
+const likeCandidates = 4
+
+// likeEngine works everywhere, every term has to appear in the title, message or source
+type likeEngine struct{}
+
+func (e *likeEngine) Search(tx *gorm.DB, p Params) ([]*models.SearchResult, error) {
+	terms := Terms(p.Query)
+	if len(terms) == 0 {
+		return []*models.SearchResult{}, nil
+	}
+
+	q := tx.Model(&models.Event{}).Scopes(p.scope)
+	for _, t := range terms {
+		like := "%" + models.EscapeLike(t) + "%"
+		q = q.Where("(events.title LIKE ? ESCAPE '!' OR events.message LIKE ? ESCAPE '!' OR events.source LIKE ? ESCAPE '!')", like, like, like)
+	}
+	var events []*models.Event
+	result := q.Order("events.timestamp DESC").Limit(p.limit() * likeCandidates).Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	results := make([]*models.SearchResult, len(events))
+	for i, event := range events {
+		results[i] = &models.SearchResult{
+			Event:   event,
+			Rank:    score(event, terms),
+			Snippet: Snippet(event, terms),
+		}
+	}
+	// Stable, so equally ranked events stay newest first...
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+	if len(results) > p.limit() {
+		results = results[:p.limit()]
+	}
+	return results, nil
+}
+
+// Count occurrences of the terms, with matches in the title worth more
+func score(event *models.Event, terms []string) float64 {
+	message := strings.ToLower(event.Message)
+	source := strings.ToLower(event.Source)
+	title := ""
+	if event.Title != nil {
+		title = strings.ToLower(*event.Title)
+	}
+	var s float64
+	for _, t := range terms {
+		t = strings.ToLower(t)
+		s += 2*float64(strings.Count(title, t)) + float64(strings.Count(message, t)) + float64(strings.Count(source, t))
+	}
+	return s
+}
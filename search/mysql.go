@@ -0,0 +1,41 @@
+package search
+
+import (
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+const mysqlMatch = "MATCH(events.title, events.message, events.source) AGAINST (? IN NATURAL LANGUAGE MODE)"
+
+// mysqlEngine uses a FULLTEXT index, MySQL can't build snippets so they are made after loading the events
+type mysqlEngine struct{}
+
+func setupMySQL(tx *gorm.DB) (Engine, error) {
+	if !tx.Migrator().HasIndex(&models.Event{}, "idx_loggo_event_fts") {
+		result := tx.Exec("ALTER TABLE events ADD FULLTEXT INDEX idx_loggo_event_fts (title, message, source)")
+		if result.Error != nil {
+			return nil, result.Error
+		}
+	}
+	return &mysqlEngine{}, nil
+}
+
+func (e *mysqlEngine) Search(tx *gorm.DB, p Params) ([]*models.SearchResult, error) {
+	terms := Terms(p.Query)
+	if len(terms) == 0 {
+		return []*models.SearchResult{}, nil
+	}
+
+	var hits []hit
+	result := tx.Table("events").
+		Select("events.id AS id, "+mysqlMatch+" AS score", p.Query).
+		Where(mysqlMatch, p.Query).
+		Scopes(p.scope).
+		Order("score DESC").
+		Limit(p.limit()).
+		Scan(&hits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return resolve(tx, hits, terms)
+}
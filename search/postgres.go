@@ -0,0 +1,41 @@
+package search
+
+import (
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+// The indexed document, queries have to use the same expression for the index to be used
+const postgresDocument = "to_tsvector('simple', coalesce(title, '') || ' ' || message || ' ' || source)"
+
+// postgresEngine uses a tsvector expression index
+type postgresEngine struct{}
+
+func setupPostgres(tx *gorm.DB) (Engine, error) {
+	result := tx.Exec("CREATE INDEX IF NOT EXISTS idx_loggo_event_fts ON events USING GIN ((" + postgresDocument + "))")
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &postgresEngine{}, nil
+}
+
+func (e *postgresEngine) Search(tx *gorm.DB, p Params) ([]*models.SearchResult, error) {
+	terms := Terms(p.Query)
+	if len(terms) == 0 {
+		return []*models.SearchResult{}, nil
+	}
+
+	var hits []hit
+	result := tx.Table("events").
+		Select("events.id AS id, ts_rank("+postgresDocument+", q) AS score").
+		Joins("CROSS JOIN plainto_tsquery('simple', ?) AS q", p.Query).
+		Where(postgresDocument + " @@ q").
+		Scopes(p.scope).
+		Order("score DESC").
+		Limit(p.limit()).
+		Scan(&hits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return resolve(tx, hits, terms)
+}
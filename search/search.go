@@ -0,0 +1,242 @@
+package search
+
+import (
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+const defaultLimit = 50
+const maxLimit = 500
+
+// Highlight markers wrapped around matches in snippets, the text around them is HTML escaped
+const (
+	markStart = "<mark>"
+	markEnd   = "</mark>"
+)
+
+type Params struct {
+	Query      string
+	ChannelIDs []uint
+	Levels     []models.EventLevel
+	From       *time.Time
+	To         *time.Time
+	Limit      int
+}
+
+// Engine searches event titles, messages and sources, best matches first
+type Engine interface {
+	Search(tx *gorm.DB, p Params) ([]*models.SearchResult, error)
+}
+
+// Set up native full-text search if the database supports it, otherwise fall back to LIKE
+func Setup(tx *gorm.DB) Engine {
+	var engine Engine
+	var err error
+	switch tx.Dialector.Name() {
+	case "sqlite":
+		engine, err = setupSQLite(tx)
+	case "postgres":
+		engine, err = setupPostgres(tx)
+	case "mysql":
+		engine, err = setupMySQL(tx)
+	default:
+		return &likeEngine{}
+	}
+	if err != nil {
+		log.Println("Full-text search is unavailable, falling back to LIKE searches", err)
+		return &likeEngine{}
+	}
+	return engine
+}
+
+func (p *Params) limit() int {
+	if p.Limit < 1 {
+		return defaultLimit
+	}
+	if p.Limit > maxLimit {
+		return maxLimit
+	}
+	return p.Limit
+}
+
+// Conditions shared by every engine
+func (p *Params) scope(tx *gorm.DB) *gorm.DB {
+	if p.ChannelIDs != nil {
+		tx = tx.Where("events.channel_id IN ?", p.ChannelIDs)
+	}
+	if len(p.Levels) > 0 {
+		tx = tx.Where("events.level IN ?", p.Levels)
+	}
+	if p.From != nil {
+		tx = tx.Where("events.timestamp >= ?", *p.From)
+	}
+	if p.To != nil {
+		tx = tx.Where("events.timestamp <= ?", *p.To)
+	}
+	return tx
+}
+
+// A ranked match found by an engine, before the event itself is loaded...
+//
+// Snippets are always built here rather than by the database, which would mix unescaped event text
+// with the markers.
+type hit struct {
+	ID    uint
+	Score float64
+}
+
+// Load the events for hits, keeping the order they were ranked in
+func resolve(tx *gorm.DB, hits []hit, terms []string) ([]*models.SearchResult, error) {
+	results := []*models.SearchResult{}
+	if len(hits) == 0 {
+		return results, nil
+	}
+	ids := make([]uint, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	var events []*models.Event
+	result := tx.Where("id IN ?", ids).Find(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	byID := map[uint]*models.Event{}
+	for _, e := range events {
+		byID[e.ID] = e
+	}
+	for _, h := range hits {
+		event, ok := byID[h.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, &models.SearchResult{Event: event, Rank: h.Score, Snippet: Snippet(event, terms)})
+	}
+	return results, nil
+}
+
+// Split a query into the terms to look for
+func Terms(query string) []string {
+	var terms []string
+	for _, t := range strings.Fields(query) {
+		t = strings.Trim(t, `"'`)
+		if len(t) > 0 {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// Build a highlighted snippet around the first match in the title or message
+func Snippet(event *models.Event, terms []string) string {
+	text := event.Message
+	if event.Title != nil && len(*event.Title) > 0 {
+		text = *event.Title + " - " + event.Message
+	}
+	return Highlight(text, terms, 80)
+}
+
+// Highlight every term in a window of roughly width characters around the first match, everything but
+// the markers is HTML escaped so the snippet is safe to show as HTML
+func Highlight(text string, terms []string, width int) string {
+	first := -1
+	for _, t := range terms {
+		if i, _ := indexFold(text, t); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	start := 0
+	if first > width/4 {
+		start = first - width/4
+	}
+	end := start + width
+	if end > len(text) {
+		end = len(text)
+	}
+	// Don't cut multi-byte characters in half...
+	for start > 0 && !utf8Start(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8Start(text[end]) {
+		end++
+	}
+
+	window := text[start:end]
+	type span struct{ from, to int }
+	var spans []span
+	for _, t := range terms {
+		for offset := 0; offset < len(window); {
+			from, to := indexFold(window[offset:], t)
+			if from < 0 {
+				break
+			}
+			spans = append(spans, span{offset + from, offset + to})
+			offset += to
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].from < spans[j].from })
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	pos := 0
+	for _, s := range spans {
+		if s.from < pos {
+			continue
+		}
+		b.WriteString(html.EscapeString(window[pos:s.from]))
+		b.WriteString(markStart)
+		b.WriteString(html.EscapeString(window[s.from:s.to]))
+		b.WriteString(markEnd)
+		pos = s.to
+	}
+	b.WriteString(html.EscapeString(window[pos:]))
+	if end < len(text) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// Find the first match of term in text ignoring case, as byte offsets into text. Matches are found in the
+// text itself rather than a lowercased copy, lowercasing can change how many bytes a character takes (Ⱥ is
+// two bytes, ⱥ three) so offsets in the copy don't line up with the original.
+func indexFold(text string, term string) (from int, to int) {
+	if len(term) == 0 {
+		return -1, -1
+	}
+	for from = 0; from < len(text); {
+		if n, ok := hasPrefixFold(text[from:], term); ok {
+			return from, from + n
+		}
+		_, size := utf8.DecodeRuneInString(text[from:])
+		from += size
+	}
+	return -1, -1
+}
+
+// Does text start with prefix ignoring case, and if so how many bytes of text does it take up?
+func hasPrefixFold(text string, prefix string) (int, bool) {
+	n := 0
+	for _, p := range prefix {
+		if n >= len(text) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(text[n:])
+		if r != p && !strings.EqualFold(string(r), string(p)) {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,45 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	got := Highlight("Disk <full> on web-1, DISK usage 99%", []string{"disk"}, 80)
+	want := "<mark>Disk</mark> &lt;full&gt; on web-1, <mark>DISK</mark> usage 99%"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHighlightWindow(t *testing.T) {
+	text := strings.Repeat("a ", 100) + "needle" + strings.Repeat(" b", 100)
+	got := Highlight(text, []string{"needle"}, 40)
+	if !strings.HasPrefix(got, "...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("expected the window to be cut at both ends, got %q", got)
+	}
+	if !strings.Contains(got, "<mark>needle</mark>") {
+		t.Errorf("expected the match to be highlighted, got %q", got)
+	}
+}
+
+// Lowercasing Ⱥ takes it from two bytes to three, so offsets in lowered text are past the end of the original
+func TestHighlightLengthChangingCase(t *testing.T) {
+	text := strings.Repeat("Ⱥ", 100) + " boom"
+	got := Highlight(text, []string{"boom"}, 80)
+	if !strings.HasSuffix(got, "<mark>boom</mark>") {
+		t.Errorf("expected boom to be highlighted at the end, got %q", got)
+	}
+
+	got = Highlight("ȺȺ boom", []string{"ⱥⱥ"}, 80)
+	if got != "<mark>ȺȺ</mark> boom" {
+		t.Errorf("expected a match across case, got %q", got)
+	}
+}
+
+func TestHighlightNoMatch(t *testing.T) {
+	if got := Highlight("nothing here", []string{"missing", ""}, 80); got != "nothing here" {
+		t.Errorf("got %q", got)
+	}
+}
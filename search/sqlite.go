@@ -0,0 +1,80 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+// sqliteEngine uses an external content FTS5 table kept in step with events by triggers...
+//
+// FTS5 has to be compiled in, which means building with the sqlite_fts5 tag.
+type sqliteEngine struct{}
+
+func setupSQLite(tx *gorm.DB) (Engine, error) {
+	var existing int64
+	result := tx.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'events_fts'").Scan(&existing)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5(title, message, source, content='events', content_rowid='id')`,
+		`CREATE TRIGGER IF NOT EXISTS events_fts_insert AFTER INSERT ON events BEGIN
+			INSERT INTO events_fts(rowid, title, message, source) VALUES (new.id, new.title, new.message, new.source);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events BEGIN
+			INSERT INTO events_fts(events_fts, rowid, title, message, source) VALUES ('delete', old.id, old.title, old.message, old.source);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS events_fts_update AFTER UPDATE ON events BEGIN
+			INSERT INTO events_fts(events_fts, rowid, title, message, source) VALUES ('delete', old.id, old.title, old.message, old.source);
+			INSERT INTO events_fts(rowid, title, message, source) VALUES (new.id, new.title, new.message, new.source);
+		END`,
+	}
+	// Index everything which was stored before search was set up...
+	if existing == 0 {
+		statements = append(statements, `INSERT INTO events_fts(events_fts) VALUES ('rebuild')`)
+	}
+
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		for _, s := range statements {
+			if err := tx.Exec(s).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w (FTS5 needs building with -tags sqlite_fts5)", err)
+	}
+	return &sqliteEngine{}, nil
+}
+
+func (e *sqliteEngine) Search(tx *gorm.DB, p Params) ([]*models.SearchResult, error) {
+	terms := Terms(p.Query)
+	if len(terms) == 0 {
+		return []*models.SearchResult{}, nil
+	}
+
+	// Quote every term so FTS5 query syntax in the input is treated as text, they are ANDed together...
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+
+	var hits []hit
+	result := tx.Table("events").
+		Select("events.id AS id, -bm25(events_fts) AS score").
+		Joins("JOIN events_fts ON events_fts.rowid = events.id").
+		Where("events_fts MATCH ?", strings.Join(quoted, " ")).
+		Scopes(p.scope).
+		Order("score DESC").
+		Limit(p.limit()).
+		Scan(&hits)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return resolve(tx, hits, terms)
+}
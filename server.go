@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
@@ -19,21 +24,45 @@ import (
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/kaigoh/loggo/auth"
+	"github.com/kaigoh/loggo/broker"
 	"github.com/kaigoh/loggo/configuration"
 	"github.com/kaigoh/loggo/database"
 	"github.com/kaigoh/loggo/graph"
 	"github.com/kaigoh/loggo/graph/generated"
+	"github.com/kaigoh/loggo/health"
+	"github.com/kaigoh/loggo/ingest"
+	"github.com/kaigoh/loggo/lifecycle"
 	"github.com/kaigoh/loggo/middleware"
 	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/ntfy"
+	"github.com/kaigoh/loggo/pubsub"
+	"github.com/kaigoh/loggo/quota"
+	"github.com/kaigoh/loggo/retention"
+	"github.com/kaigoh/loggo/search"
+	"github.com/kaigoh/loggo/storage"
+	"github.com/kaigoh/loggo/syslog"
+	"github.com/kaigoh/loggo/telemetry"
 	mqtt "github.com/mochi-co/mqtt/server"
 	"github.com/mochi-co/mqtt/server/events"
-	"github.com/mochi-co/mqtt/server/listeners"
 	"gorm.io/gorm"
 )
 
 var config configuration.Config
 var db *gorm.DB
 var mqttServer *mqtt.Server
+var eventHub = pubsub.NewHub()
+var notifier *ntfy.Notifier
+var guard *auth.Guard
+var searchEngine search.Engine
+var purger *retention.Purger
+var pipeline *ingest.Pipeline
+
+// How long each readiness check has before it counts as failed
+const readinessTimeout = 5 * time.Second
+
+// Batches are read into memory, so keep them to a sensible size...
+const maxBatchBytes = 32 * 1024 * 1024
 
 func main() {
 
@@ -41,58 +70,121 @@ func main() {
 	fmt.Println(" Loggo Server ")
 	fmt.Println("--------------")
 
+	// Run until we're told to stop...
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	err := run(ctx, os.Args[1:])
+	stop()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+}
+
+// Start everything and run until the context is done, then shut down cleanly
+func run(ctx context.Context, args []string) error {
+
 	// Load config...
 	_, err := config.LoadConfig()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	shutdownTimeout, err := config.GetShutdownTimeout()
+	if err != nil {
+		return fmt.Errorf("invalid shutdown timeout: %w", err)
+	}
+	app := lifecycle.New(shutdownTimeout)
+	app.Grace, err = config.GetReadinessGracePeriod()
+	if err != nil {
+		return fmt.Errorf("invalid readiness grace period: %w", err)
 	}
-
-	// Set the "PORT" variable...
-	os.Setenv("PORT", strconv.Itoa(int(config.Server.HTTPPort)))
 
 	// Connect to the database...
-	db = database.Connect(&config)
+	db, err = database.Connect(ctx, &config)
+	if err != nil {
+		return err
+	}
+
+	// Restoring an archive doesn't need the rest of the server...
+	if len(args) > 0 && args[0] == "restore" {
+		return restoreCommand(args[1:])
+	}
+
+	// ntfy notifications...
+	notifier, err = ntfy.NewNotifier(&config, db)
+	if err != nil {
+		return err
+	}
+	notifier.Start()
+
+	// API keys...
+	guard = auth.NewGuard(&config, db)
 
-	// Start cron tasks...
-	go cronHandler()
+	// Full-text search...
+	searchEngine = search.Setup(db)
+
+	// Start purging expired events...
+	purger, err = retention.NewPurger(&config, db)
+	if err != nil {
+		return err
+	}
+
+	// Queue new events for the database writers...
+	pipeline, err = ingest.NewPipeline(&config, db)
+	if err != nil {
+		return err
+	}
+	if pipeline.Spool != nil {
+		pipeline.Spool.Replayed = publishReplayed
+	}
+	pipeline.Start()
 
 	// MQTT
 	mqttServer = mqtt.NewServer(nil)
-	tcp := listeners.NewTCP("t1", ":"+strconv.Itoa(int(config.Server.MQTTPort)))
-	err = mqttServer.AddListener(tcp, nil)
+	mqttWebsocket, err := broker.AddListeners(mqttServer, &config, broker.NewAuthController(guard, db))
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	// Once we start shutting down publishes are refused before they are acknowledged, so clients send them
+	// again (to us or another instance) rather than them being lost to a pipeline which has closed. The MQTT
+	// broker itself stays up until queued events have been written and published...
+	mqttServer.Events.OnProcessMessage = func(cl events.Client, pk events.Packet) (events.Packet, error) {
+		if app.Stopping() {
+			return pk, mqtt.ErrRejectPacket
+		}
+		return pk, nil
+	}
+
+	// Mochi has already acknowledged a message by the time OnMessage sees it, and still sends the original
+	// on to subscribers if OnMessage returns an error, so messages which aren't saved are dropped instead...
+	reject := func(pk events.Packet, reason string, err error) (events.Packet, error) {
+		log.Println("Rejected MQTT message for '"+pk.TopicName+"'", err)
+		telemetry.MQTTRejected.WithLabelValues(reason).Inc()
+		pk.AllowClients = []string{}
+		return pk, nil
 	}
 	mqttServer.Events.OnMessage = func(cl events.Client, pk events.Packet) (pkx events.Packet, err error) {
 		if pk.FixedHeader.Type == byte(3) {
+			telemetry.PayloadSize.WithLabelValues(telemetry.TransportMQTT).Observe(float64(len(pk.Payload)))
 
-			// Parse the topic name is if it were a URL...
-			u, err := url.Parse(pk.TopicName)
+			topic, params, err := broker.ParseTopic(pk.TopicName)
 			if err != nil {
 				return pkx, err
 			}
-			p := strings.Split(u.Path, "/")
-			var s []string
-			for _, e := range p {
-				if len(e) > 0 {
-					s = append(s, e)
-				}
-			}
-			if len(s) < 2 || s[0] != "channel" {
-				return pkx, fmt.Errorf("topic '" + s[1] + "' is not a valid channel")
-			}
 
 			// Get the channel from the topic...
-			channel, err := models.ChannelByMQTTTopic(db, s[1])
+			channel, err := models.ChannelByMQTTTopic(db, topic)
 			if err != nil {
-				return pkx, err
+				return reject(pk, telemetry.RejectUnknownChannel, err)
 			}
 
 			// Our new event...
 			var newEvent models.NewEvent
 			newEvent.ChannelID = channel.ID
 
-			params := u.Query()
+			// Attributes (attr.<key>) and trace context can be given as parameters whatever the payload is...
+			attributes := models.ParameterAttributes(params)
+			trace := models.ParameterTraceContext(params)
 
 			// If we want data stored with the event, the new event data comes from "URL" parameters in the topic
 			// This means if we are just firing events with no payload, we can upload that data in multiple formats,
@@ -122,19 +214,28 @@ func main() {
 				// Try and decode the payload...
 				err = newEvent.FromData(pk.Payload)
 				if err != nil {
-					log.Println(err)
-					return pkx, err
+					return reject(pk, telemetry.RejectInvalid, err)
 				}
 
 			}
+			newEvent.Attributes.Merge(attributes)
+			newEvent.SetTraceContext(trace)
 
 			event, err := newEvent.ToEvent()
 			if err != nil {
-				return pkx, err
+				return reject(pk, telemetry.RejectInvalid, err)
 			}
 
+			// Save it, rejecting the message if the channel is over its quota...
+			if err = saveEvent(context.Background(), telemetry.TransportMQTT, channel, &event); err != nil {
+				// Spooled events are published once they are replayed...
+				if errors.Is(err, ingest.ErrSpooled) {
+					pk.AllowClients = []string{}
+					return pk, nil
+				}
+				return reject(pk, rejectReason(err), err)
+			}
 			pkx.WillRetain = true
-			db.Create(&event)
 
 			out, err := event.ToJSON()
 			if err != nil {
@@ -144,7 +245,7 @@ func main() {
 			pkx.Payload = out
 
 			// Put the event on the wire...
-			defer publishEvent(channel, &event, &out)
+			defer publishEvent(telemetry.TransportMQTT, channel, &event, &out)
 
 			return pkx, nil
 
@@ -152,17 +253,64 @@ func main() {
 
 		return pk, nil
 	}
-	go mqttHandler()
+
+	// Syslog, for anything which can't speak HTTP or MQTT...
+	var syslogServer *syslog.Server
+	if config.Syslog.Enabled {
+		syslogServer, err = syslog.NewServer(&config, database.Location(), saveSyslogEvent)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Prometheus gauges...
+	telemetry.MQTTClients.Set(func() float64 {
+		return float64(atomic.LoadInt64(&mqttServer.System.ClientsConnected))
+	})
+	telemetry.GraphQLSubscriptions.Set(func() float64 {
+		return float64(eventHub.Count())
+	})
 
 	// HTTP
 	r := gin.Default()
 	r.Use(middleware.GinContextToContextMiddleware())
 	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	r.Use(guard.Middleware())
 
-	r.POST("/api", graphqlHandler(db))
+	// GET is needed for the websocket transport (subscriptions)...
+	api := graphqlHandler(db)
+	loaders := storage.GinMiddleware(db)
+	r.POST("/api", loaders, api)
+	r.GET("/api", loaders, api)
 	r.GET("/playground", playgroundHandler())
+	// Go's own runtime stats, Loggo's metrics are all on /metrics...
+	r.GET("/debug/vars", guard.Require(models.PermissionAdmin), gin.WrapH(expvar.Handler()))
+	r.GET("/metrics", gin.WrapH(telemetry.Handler()))
+
+	// Probes, readiness fails as soon as we start shutting down so no new work is sent our way...
+	readiness := health.NewChecker(readinessTimeout)
+	readiness.Add("app", health.Condition(func() bool { return !app.Stopping() }, "shutting down"))
+	readiness.Add("database", health.Database(db))
+	readiness.Add("migrations", health.Condition(database.Migrated, "migrations have not completed"))
+	readiness.Add("mqtt", health.Listener("127.0.0.1:"+strconv.Itoa(int(config.Server.MQTTPort))))
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+	})
+	r.GET("/readyz", func(c *gin.Context) {
+		report := readiness.Check(c.Request.Context())
+		if !report.Ready() {
+			c.JSON(http.StatusServiceUnavailable, report)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	})
 
-	r.GET("/channel/:channelName/event", func(c *gin.Context) {
+	// MQTT over websockets, when it is sharing the HTTP server...
+	if mqttWebsocket != nil {
+		r.GET(config.Server.MQTTWebsocket.Path, gin.WrapH(mqttWebsocket))
+	}
+
+	r.GET("/channel/:channelName/event", guard.RequireChannel(models.PermissionWrite, channelFromParam), func(c *gin.Context) {
 
 		// Get the channel...
 		channel, err := models.ChannelByName(db, c.Param("channelName"))
@@ -176,32 +324,45 @@ func main() {
 		n.ChannelID = channel.ID
 
 		// Try headers first, then fall back to a standard binding...
-		err = c.BindHeader(&n)
+		err = c.ShouldBindHeader(&n)
 		if err != nil {
-			err = c.Bind(&n)
+			err = c.ShouldBind(&n)
 			if err != nil {
 				c.AbortWithError(400, err)
 				return
 			}
 		}
 
+		// Attributes come from Loggo-Attr-* headers and attr.* parameters...
+		n.Attributes.Merge(models.HeaderAttributes(c.Request.Header))
+		n.Attributes.Merge(models.ParameterAttributes(c.Request.URL.Query()))
+		n.SetTraceContext(models.HeaderTraceContext(c.Request.Header))
+
 		event, err := n.ToEvent()
 		if err != nil {
-			c.AbortWithError(500, err)
+			c.AbortWithError(400, err)
 			return
 		}
 
 		// Save it...
-		db.Create(&event)
+		if err = saveEvent(c.Request.Context(), telemetry.TransportHTTPGet, channel, &event); err != nil {
+			// Spooled events are published once they are replayed...
+			if errors.Is(err, ingest.ErrSpooled) {
+				c.JSON(http.StatusAccepted, event)
+				return
+			}
+			c.AbortWithError(saveStatus(err), err)
+			return
+		}
 
 		// Put the event on the wire...
-		defer publishEvent(channel, &event, nil)
+		defer publishEvent(telemetry.TransportHTTPGet, channel, &event, nil)
 
 		c.JSON(200, event)
 
 	})
 
-	r.POST("/channel/:channelName/event", func(c *gin.Context) {
+	r.POST("/channel/:channelName/event", guard.RequireChannel(models.PermissionWrite, channelFromParam), func(c *gin.Context) {
 
 		// Get the channel...
 		channel, err := models.ChannelByName(db, c.Param("channelName"))
@@ -215,9 +376,9 @@ func main() {
 		n.ChannelID = channel.ID
 
 		// Try headers first, then fall back to a standard binding...
-		err = c.BindHeader(&n)
+		err = c.ShouldBindHeader(&n)
 		if err != nil {
-			err = c.Bind(&n)
+			err = c.ShouldBind(&n)
 			if err != nil {
 				c.AbortWithError(400, err)
 				return
@@ -231,23 +392,123 @@ func main() {
 			c.AbortWithError(500, err)
 			return
 		}
+		telemetry.PayloadSize.WithLabelValues(telemetry.TransportHTTPPost).Observe(float64(len(d)))
 		n.Data = &d
+		n.Attributes.Merge(models.HeaderAttributes(c.Request.Header))
+		n.SetTraceContext(models.HeaderTraceContext(c.Request.Header))
 		event, err := n.ToEvent()
 		if err != nil {
-			c.AbortWithError(500, err)
+			c.AbortWithError(400, err)
 			return
 		}
 
 		// Save it...
-		db.Create(&event)
+		if err = saveEvent(c.Request.Context(), telemetry.TransportHTTPPost, channel, &event); err != nil {
+			// Spooled events are published once they are replayed...
+			if errors.Is(err, ingest.ErrSpooled) {
+				c.JSON(http.StatusAccepted, event)
+				return
+			}
+			c.AbortWithError(saveStatus(err), err)
+			return
+		}
 
 		// Put the event on the wire...
-		defer publishEvent(channel, &event, nil)
+		defer publishEvent(telemetry.TransportHTTPPost, channel, &event, nil)
 
 		c.JSON(200, event)
 	})
 
-	r.GET("/channel/:channelName/event/:eventId/data", func(c *gin.Context) {
+	r.POST("/channel/:channelName/events", guard.RequireChannel(models.PermissionWrite, channelFromParam), func(c *gin.Context) {
+
+		// Get the channel...
+		channel, err := models.ChannelByName(db, c.Param("channelName"))
+		if err != nil {
+			c.AbortWithError(404, err)
+			return
+		}
+
+		// Decode the batch, items which aren't valid are reported back rather than failing everything...
+		body, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBytes))
+		if err != nil {
+			c.AbortWithError(http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		telemetry.PayloadSize.WithLabelValues(telemetry.TransportHTTPPost).Observe(float64(len(body)))
+		items, err := models.DecodeNewEvents(body, c.ContentType())
+		if err != nil {
+			c.AbortWithError(400, err)
+			return
+		}
+
+		result := models.BatchResult{Results: make([]*models.BatchItemResult, len(items))}
+		var events []*models.Event
+		attributes := models.HeaderAttributes(c.Request.Header)
+		trace := models.HeaderTraceContext(c.Request.Header)
+		for i, item := range items {
+			res := &models.BatchItemResult{Index: i}
+			result.Results[i] = res
+			err := item.Err
+			if err == nil {
+				item.Event.ChannelID = channel.ID
+				item.Event.Attributes.Merge(attributes)
+				item.Event.SetTraceContext(trace)
+				var event models.Event
+				event, err = item.Event.ToEvent()
+				if err == nil {
+					res.Event = &event
+					events = append(events, &event)
+					continue
+				}
+			}
+			msg := err.Error()
+			res.Error = &msg
+			result.Failed++
+		}
+
+		// Save them all at once...
+		if len(events) > 0 {
+			err = saveEvents(c.Request.Context(), telemetry.TransportHTTPPost, channel, events)
+			switch {
+			case errors.Is(err, ingest.ErrSpooled):
+				result.Spooled = len(events)
+			case err != nil:
+				c.AbortWithError(saveStatus(err), err)
+				return
+			default:
+				result.Created = len(events)
+
+				// ...and put them on the wire
+				for _, event := range events {
+					publishEvent(telemetry.TransportHTTPPost, channel, event, nil)
+				}
+			}
+		}
+
+		status := 200
+		switch {
+		case result.Spooled > 0:
+			status = http.StatusAccepted
+		case result.Created == 0 && result.Failed > 0:
+			status = 400
+		}
+		c.JSON(status, result)
+	})
+
+	// OpenTelemetry logs, the channel for each is picked per resource so permissions are checked as they're saved...
+	r.POST("/v1/logs", otlpLogs)
+
+	// ...and the same for Loki and Elasticsearch clients
+	r.POST("/loki/api/v1/push", lokiPush)
+	r.POST("/_bulk", elasticBulk)
+	r.POST("/:index/_bulk", elasticBulk)
+	// The /channel routes take precedence over :index, so an index named channel needs a route of its own
+	r.POST("/channel/_bulk", func(c *gin.Context) {
+		c.Params = append(c.Params, gin.Param{Key: "index", Value: "channel"})
+		elasticBulk(c)
+	})
+
+	r.GET("/channel/:channelName/event/:eventId/data", guard.RequireChannel(models.PermissionRead, channelFromParam), func(c *gin.Context) {
 		var data *models.EventData
 		sub := db.Select("id").Where("name = ?", c.Param("channelName")).Limit(1).Model(&models.Channel{})
 		result := db.Where("id = ? AND channel_id IN (?)", c.Param("eventId"), sub).Find(&data)
@@ -262,51 +523,142 @@ func main() {
 		c.Data(200, data.DataMIMEType, data.Data)
 	})
 
-	r.GET("/mqtt/:topic/:message", func(c *gin.Context) {
+	r.GET("/mqtt/:topic/:message", guard.RequireChannel(models.PermissionWrite, func(c *gin.Context) (*models.Channel, error) {
+		return models.ChannelByMQTTTopic(db, c.Param("topic"))
+	}), func(c *gin.Context) {
 		err := mqttServer.Publish("/channel/"+c.Param("topic"), []byte(c.Param("message")), false)
 		if err != nil {
 			c.AbortWithError(500, err)
 		}
 	})
-	r.Run()
+	srv := &http.Server{
+		Addr:    ":" + strconv.Itoa(int(config.Server.HTTPPort)),
+		Handler: r,
+	}
+
+	// Services stop in reverse, so HTTP stops taking events first (MQTT publishes are refused as soon as we
+	// start stopping), then queued events are written before MQTT (which they are published to) closes, ntfy
+	// sends what those events queued, and the database goes last...
+	app.Add("database", nil, func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.Close()
+	})
+	app.Add("ntfy notifications", nil, notifier.Close)
+	app.Add("retention", func(ctx context.Context) error {
+		purger.Run(ctx)
+		return nil
+	}, nil)
+	app.Add("MQTT broker", func(ctx context.Context) error {
+		return mqttServer.Serve()
+	}, func(ctx context.Context) error {
+		return mqttServer.Close()
+	})
+	app.Add("ingestion pipeline", nil, pipeline.Close)
+	if syslogServer != nil {
+		app.Add("syslog", func(ctx context.Context) error {
+			return syslogServer.Serve()
+		}, syslogServer.Close)
+	}
+	app.Add("HTTP server", func(ctx context.Context) error {
+		log.Println("Listening for HTTP on " + srv.Addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}, srv.Shutdown)
+
+	return app.Run(ctx)
 
 }
 
-func cronHandler() {
-	log.Println("Starting cron tasks")
+func channelFromParam(c *gin.Context) (*models.Channel, error) {
+	return models.ChannelByName(db, c.Param("channelName"))
+}
 
-	ticker := time.NewTicker(1 * time.Hour)
-	for range ticker.C {
-		log.Println("Running cron tasks...")
+// Save a new event, making room for it (or refusing it) if its channel has a quota
+func saveEvent(ctx context.Context, transport string, channel *models.Channel, event *models.Event) error {
+	return saveEvents(ctx, transport, channel, []*models.Event{event})
+}
 
-		// Purge expired events...
-		var channels []*models.Channel
-		db.Find(channels)
-		if len(channels) > 0 {
-			for _, c := range channels {
-				// Get the TTL for the channels events...
-				ttl, err := c.GetTTL(&config)
-				if err != nil {
-					log.Println("Unable to process TTL for channel '" + c.Name + "' - events will NOT be purged!")
-				} else {
-					// ...and purge events which have expired
-					window := time.Now().Add(-ttl)
-					result := db.Where("channel_id = ? AND timestamp <= ?", c.ID, window).Delete(&models.Event{})
-					if result.Error != nil {
-						log.Println("Database error pruning events for channel '"+c.Name+"'", result.Error)
-					} else {
-						if result.RowsAffected > 0 {
-							log.Println("Purged " + strconv.Itoa(int(result.RowsAffected)) + " events for channel '" + c.Name + "'")
-						}
-					}
-				}
-			}
+// Save new events through the write pipeline, they are written together and the quota applies to all of them
+func saveEvents(ctx context.Context, transport string, channel *models.Channel, events []*models.Event) error {
+	return pipeline.Submit(ctx, transport, channel, events)
+}
+
+// The HTTP status for events which couldn't be saved
+func saveStatus(err error) int {
+	switch {
+	case errors.Is(err, quota.ErrQuotaExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ingest.ErrQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ingest.ErrClosed):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// Why an event couldn't be saved, for counting rejected messages
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, quota.ErrQuotaExceeded):
+		return telemetry.RejectQuota
+	case errors.Is(err, ingest.ErrQueueFull):
+		return telemetry.RejectQueueFull
+	case errors.Is(err, ingest.ErrClosed):
+		return telemetry.RejectClosed
+	}
+	return telemetry.RejectError
+}
+
+// Save an event from a syslog message, which has been routed to a channel by name
+func saveSyslogEvent(channelName string, n *models.NewEvent) error {
+	channel, err := models.ChannelByName(db, channelName)
+	if err != nil {
+		return err
+	}
+	n.ChannelID = channel.ID
+	event, err := n.ToEvent()
+	if err != nil {
+		return err
+	}
+	if err = saveEvent(context.Background(), telemetry.TransportSyslog, channel, &event); err != nil {
+		// Spooled events are published once they are replayed...
+		if errors.Is(err, ingest.ErrSpooled) {
+			return nil
 		}
+		return err
+	}
+	return publishEvent(telemetry.TransportSyslog, channel, &event, nil)
+}
 
+// Publish an event replayed from the spool, which was held back when it was spooled
+func publishReplayed(event *models.Event, transport string) {
+	channel, err := models.ChannelByID(db, event.ChannelID)
+	if err != nil {
+		log.Println("Unable to publish replayed event", err)
+		return
+	}
+	if transport == "" {
+		transport = telemetry.TransportSpool
+	}
+	if err := publishEvent(transport, channel, event, nil); err != nil {
+		log.Println("Unable to publish replayed event", err)
 	}
 }
 
-func publishEvent(channel *models.Channel, event *models.Event, json *[]byte) (err error) {
+func publishEvent(transport string, channel *models.Channel, event *models.Event, json *[]byte) (err error) {
+	telemetry.EventsIngested.WithLabelValues(channel.Name, event.Level.String(), transport).Inc()
+
+	// GraphQL subscribers...
+	eventHub.Publish(event)
+
+	// ntfy, queued for the workers so a slow server doesn't hold anything up...
+	notifier.Queue(channel, event)
+
 	var out []byte
 	if json == nil {
 		out, err = event.ToJSON()
@@ -319,20 +671,17 @@ func publishEvent(channel *models.Channel, event *models.Event, json *[]byte) (e
 	return mqttServer.Publish("/channel/"+*channel.MQTTTopic, out, false)
 }
 
-func mqttHandler() {
-	err := mqttServer.Serve()
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 func graphqlHandler(tx *gorm.DB) gin.HandlerFunc {
 	// NewExecutableSchema and Config are in the generated.go file
 	// Resolver is in the resolver.go file
 	c := generated.Config{Resolvers: &graph.Resolver{
-		DB:     tx,
-		Config: &config,
+		DB:       tx,
+		Config:   &config,
+		PubSub:   eventHub,
+		Searcher: searchEngine,
+		Purger:   purger,
 	}}
+	c.Directives.HasPermission = auth.HasPermission
 
 	h := handler.New(generated.NewExecutableSchema(c))
 
@@ -346,6 +695,24 @@ func graphqlHandler(tx *gorm.DB) gin.HandlerFunc {
 			WriteBufferSize: 1024,
 		},
 		KeepAlivePingInterval: 10 * time.Second,
+		InitFunc: func(ctx context.Context, payload transport.InitPayload) (context.Context, error) {
+			// Browsers can't set headers on a websocket, so the token can also come in the init payload...
+			if auth.ForContext(ctx) != nil {
+				return ctx, nil
+			}
+			token := payload.GetString(auth.TokenHeader)
+			if len(token) == 0 {
+				token = auth.BearerToken(payload.Authorization())
+			}
+			if len(token) == 0 {
+				return ctx, nil
+			}
+			principal, err := guard.Authenticate(token)
+			if err != nil {
+				return ctx, err
+			}
+			return auth.WithPrincipal(ctx, principal), nil
+		},
 	})
 
 	h.AddTransport(transport.Options{})
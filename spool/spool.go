@@ -0,0 +1,469 @@
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/quota"
+	"github.com/kaigoh/loggo/telemetry"
+	"gorm.io/gorm"
+)
+
+// Records replayed per transaction
+const replayBatchSize = 100
+
+// How many times a record is retried while the database is up before it is set aside
+const maxAttempts = 3
+
+// Records longer than this (mostly event data) can't be replayed
+const maxRecordSize = 64 * 1024 * 1024
+
+// Records which can never be replayed, or are over their channel's quota, are moved here rather than holding up the rest
+const rejectedFile = "rejected.ndjson"
+
+// Record is a single spooled event, one per line of a segment file
+type Record struct {
+	SpoolID      string          `json:"spool_id"`
+	Transport    string          `json:"transport,omitempty"`
+	Event        json.RawMessage `json:"event"`
+	DataMIMEType string          `json:"data_mime_type,omitempty"`
+	Data         []byte          `json:"data,omitempty"`
+}
+
+// Spool is a write-ahead log for events which couldn't be written to the database...
+//
+// Events are appended to segment files and replayed in order once the database is back. Each event is
+// given a spool ID when it is spooled, which is saved with it, so replaying a segment a second time (say
+// after a crash part way through) skips the events it already wrote rather than duplicating them.
+type Spool struct {
+	Directory   string
+	SegmentSize int64
+	// Called with each event once it has been replayed into the database, and how it originally reached us
+	Replayed func(event *models.Event, transport string)
+	interval time.Duration
+	tx       *gorm.DB
+
+	// The segment being written to and how many records are waiting to be replayed
+	mu      sync.Mutex
+	current *os.File
+	size    int64
+	next    uint64
+	pending int64
+
+	// Only one replay runs at a time
+	replaying sync.Mutex
+	attempts  map[string]int
+	wake      chan struct{}
+}
+
+func NewSpool(config *configuration.Config, tx *gorm.DB) (*Spool, error) {
+	interval, err := config.GetSpoolReplayInterval()
+	if err != nil {
+		return nil, fmt.Errorf("invalid spool replay interval: %w", err)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("spool replay interval must be positive")
+	}
+	if len(strings.TrimSpace(config.Spool.Directory)) == 0 {
+		return nil, fmt.Errorf("spool directory cannot be empty")
+	}
+	if err := os.MkdirAll(config.Spool.Directory, 0774); err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		Directory:   config.Spool.Directory,
+		SegmentSize: int64(config.Spool.SegmentSize),
+		interval:    interval,
+		tx:          tx,
+		attempts:    map[string]int{},
+		wake:        make(chan struct{}, 1),
+	}
+
+	// Pick up anything left over from last time, new records always go in a new segment...
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range segments {
+		n, err := countLines(path)
+		if err != nil {
+			return nil, err
+		}
+		s.pending += n
+		if seq, err := segmentSequence(path); err == nil && seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	if s.pending > 0 {
+		log.Println("Spool has " + strconv.FormatInt(s.pending, 10) + " events waiting to be replayed")
+	}
+	telemetry.SpoolPending.Set(func() float64 { return float64(s.Pending()) })
+	return s, nil
+}
+
+// Events spooled and not yet replayed
+func (s *Spool) Pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Append events which came in over a transport to the spool, they are synced to disk before returning
+func (s *Spool) Write(transport string, events []*models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		path := filepath.Join(s.Directory, fmt.Sprintf("segment-%020d.ndjson", s.next))
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+		if err != nil {
+			return err
+		}
+		s.next++
+		s.current = f
+		s.size = 0
+	}
+
+	w := bufio.NewWriter(s.current)
+	for _, event := range events {
+		id := uuid.NewString()
+		event.SpoolID = &id
+		out, err := event.ToJSON()
+		if err != nil {
+			return err
+		}
+		record := Record{SpoolID: id, Transport: transport, Event: out}
+		if event.HasData {
+			record.DataMIMEType = event.EventData.DataMIMEType
+			record.Data = event.EventData.Data
+		}
+		line, err := json.Marshal(&record)
+		if err != nil {
+			return err
+		}
+		n, err := w.Write(append(line, '\n'))
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := s.current.Sync(); err != nil {
+		return err
+	}
+	s.pending += int64(len(events))
+	telemetry.SpoolEvents.WithLabelValues(telemetry.ResultSpooled).Add(float64(len(events)))
+
+	if s.size >= s.SegmentSize {
+		s.rotate()
+	}
+	s.poke()
+	return nil
+}
+
+// Close the segment being written to, so it can be replayed
+func (s *Spool) rotate() {
+	if s.current == nil {
+		return
+	}
+	if err := s.current.Close(); err != nil {
+		log.Println("Unable to close spool segment", err)
+	}
+	s.current = nil
+}
+
+// Count what is left to replay, records retried after an error are read more than once so keeping a
+// running total isn't reliable
+func (s *Spool) recount() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	segments, err := s.segments()
+	if err != nil {
+		log.Println("Unable to list spool segments", err)
+		return
+	}
+	pending := int64(0)
+	for _, path := range segments {
+		n, err := countLines(path)
+		if err != nil {
+			log.Println("Unable to read spool segment", err)
+			return
+		}
+		pending += n
+	}
+	s.pending = pending
+}
+
+// Ask for a replay without waiting for the next tick
+func (s *Spool) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Replay on a schedule until the context is done
+func (s *Spool) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.rotate()
+			s.mu.Unlock()
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		if s.Pending() == 0 {
+			continue
+		}
+		if n, err := s.Replay(); err != nil {
+			log.Println("Spool replay stopped after "+strconv.Itoa(n)+" events, will retry", err)
+		} else if n > 0 {
+			log.Println("Replayed " + strconv.Itoa(n) + " spooled events")
+		}
+	}
+}
+
+// Replay every spooled event, oldest first, stopping at the first error
+func (s *Spool) Replay() (replayed int, err error) {
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	// There's no point trying while the database is still down...
+	sqlDB, err := s.tx.DB()
+	if err != nil {
+		return 0, err
+	}
+	if err = sqlDB.Ping(); err != nil {
+		return 0, err
+	}
+
+	// Close off the current segment, anything spooled from here on goes in a new one and waits for next time...
+	s.mu.Lock()
+	s.rotate()
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	defer s.recount()
+
+	for _, path := range segments {
+		n, err := s.replaySegment(path)
+		replayed += n
+		if err != nil {
+			return replayed, err
+		}
+		if err = os.Remove(path); err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+func (s *Spool) replaySegment(path string) (replayed int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	var batch []*Record
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		record := &Record{}
+		if err := json.Unmarshal(line, record); err != nil {
+			// Most likely the tail of a write which was cut short...
+			s.reject(append([]byte{}, line...), err)
+			continue
+		}
+		batch = append(batch, record)
+		if len(batch) == replayBatchSize {
+			n, err := s.replayBatch(batch)
+			replayed += n
+			if err != nil {
+				return replayed, err
+			}
+			batch = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return replayed, err
+	}
+	n, err := s.replayBatch(batch)
+	return replayed + n, err
+}
+
+// Replay records in one transaction, falling back to one at a time to find any which can't be written
+func (s *Spool) replayBatch(batch []*Record) (int, error) {
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	events, err := s.insert(batch)
+	if err == nil {
+		s.replayed(events)
+		return len(events), nil
+	}
+
+	replayed := 0
+	for _, record := range batch {
+		events, err := s.insert([]*Record{record})
+		if err == nil {
+			s.replayed(events)
+			replayed += len(events)
+			continue
+		}
+		// Events over their channel's quota would be turned away however many times they were tried...
+		if errors.Is(err, quota.ErrQuotaExceeded) {
+			line, _ := json.Marshal(record)
+			s.reject(line, err)
+			continue
+		}
+		// If the database has gone away again, try again later...
+		if sqlDB, dberr := s.tx.DB(); dberr != nil || sqlDB.Ping() != nil {
+			return replayed, err
+		}
+		s.attempts[record.SpoolID]++
+		if s.attempts[record.SpoolID] < maxAttempts {
+			return replayed, err
+		}
+		line, _ := json.Marshal(record)
+		s.reject(line, err)
+		delete(s.attempts, record.SpoolID)
+	}
+	return replayed, nil
+}
+
+// An event written by a replay, and how it originally reached us
+type replayedEvent struct {
+	event     *models.Event
+	transport string
+}
+
+// Write records which aren't already in the database, returning the events written
+func (s *Spool) insert(records []*Record) (events []replayedEvent, err error) {
+	err = s.tx.Transaction(func(tx *gorm.DB) (err error) {
+		events = nil
+		channels := map[uint]*models.Channel{}
+		for _, record := range records {
+			var count int64
+			result := tx.Model(&models.Event{}).Where("spool_id = ?", record.SpoolID).Count(&count)
+			if result.Error != nil {
+				return result.Error
+			}
+			if count > 0 {
+				continue
+			}
+
+			event := &models.Event{}
+			if err := json.Unmarshal(record.Event, event); err != nil {
+				return err
+			}
+			event.ID = 0
+			id := record.SpoolID
+			event.SpoolID = &id
+			if record.Data != nil {
+				event.EventData = models.EventData{DataMIMEType: record.DataMIMEType, Data: record.Data}
+			}
+			// The channel's quota applies as if the event had been written when it arrived...
+			channel, ok := channels[event.ChannelID]
+			if !ok {
+				if channel, err = models.ChannelByID(tx, event.ChannelID); err != nil {
+					return err
+				}
+				channels[event.ChannelID] = channel
+			}
+			if err := quota.Enforce(tx, channel, []*models.Event{event}); err != nil {
+				return err
+			}
+			if result = tx.Create(event); result.Error != nil {
+				return result.Error
+			}
+			events = append(events, replayedEvent{event: event, transport: record.Transport})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *Spool) replayed(events []replayedEvent) {
+	telemetry.SpoolEvents.WithLabelValues(telemetry.ResultReplayed).Add(float64(len(events)))
+	if s.Replayed != nil {
+		for _, e := range events {
+			s.Replayed(e.event, e.transport)
+		}
+	}
+}
+
+func (s *Spool) reject(line []byte, err error) {
+	log.Println("Unable to replay spooled event, moving it to "+rejectedFile, err)
+	telemetry.SpoolEvents.WithLabelValues(telemetry.ResultRejected).Inc()
+	f, ferr := os.OpenFile(filepath.Join(s.Directory, rejectedFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if ferr != nil {
+		log.Println("Unable to open "+rejectedFile, ferr)
+		return
+	}
+	defer f.Close()
+	if _, ferr = f.Write(append(line, '\n')); ferr != nil {
+		log.Println("Unable to write to "+rejectedFile, ferr)
+	}
+}
+
+// Segment files, oldest first
+func (s *Spool) segments() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.Directory, "segment-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func segmentSequence(path string) (uint64, error) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "segment-"), ".ndjson")
+	return strconv.ParseUint(name, 10, 64)
+}
+
+func countLines(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	n := int64(0)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/graph-gophers/dataloader"
+	"github.com/kaigoh/loggo/models"
+	"gorm.io/gorm"
+)
+
+type AttributeReader struct {
+	tx *gorm.DB
+}
+
+// Attributes for a batch of events, keyed by event ID. Events without any get an empty list rather than an error
+func (c *AttributeReader) GetAttributes(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+	ids := make([]string, len(keys))
+	for ix, key := range keys {
+		ids[ix] = key.String()
+	}
+	output := make([]*dataloader.Result, len(keys))
+	var attrs []models.EventAttribute
+	result := c.tx.Where("event_id IN ?", ids).Order("name ASC").Find(&attrs)
+	if result.Error != nil {
+		for index := range keys {
+			output[index] = &dataloader.Result{Data: nil, Error: result.Error}
+		}
+		return output
+	}
+	attrsByEventID := map[string][]models.EventAttribute{}
+	for _, v := range attrs {
+		key := strconv.Itoa(int(v.EventID))
+		attrsByEventID[key] = append(attrsByEventID[key], v)
+	}
+	for index, eventKey := range keys {
+		output[index] = &dataloader.Result{Data: attrsByEventID[eventKey.String()], Error: nil}
+	}
+	return output
+}
+
+func GetEventAttributes(ctx context.Context, eventID uint) ([]models.EventAttribute, error) {
+	loaders := For(ctx)
+	thunk := loaders.AttributeLoader.Load(ctx, dataloader.StringKey(strconv.Itoa(int(eventID))))
+	result, err := thunk()
+	if err != nil {
+		return nil, err
+	}
+	return result.([]models.EventAttribute), nil
+}
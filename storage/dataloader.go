@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/gin-gonic/gin"
 	"github.com/graph-gophers/dataloader"
 	"gorm.io/gorm"
 )
@@ -15,16 +16,19 @@ const (
 )
 
 type Loaders struct {
-	ChannelLoader *dataloader.Loader
-	EventLoader   *dataloader.Loader
+	ChannelLoader   *dataloader.Loader
+	EventLoader     *dataloader.Loader
+	AttributeLoader *dataloader.Loader
 }
 
 func NewLoaders(tx *gorm.DB) *Loaders {
 	channelReader := &ChannelReader{tx: tx}
 	eventReader := &EventReader{tx: tx}
+	attributeReader := &AttributeReader{tx: tx}
 	loaders := &Loaders{
-		ChannelLoader: dataloader.NewBatchedLoader(channelReader.GetChannels),
-		EventLoader:   dataloader.NewBatchedLoader(eventReader.GetEvents),
+		ChannelLoader:   dataloader.NewBatchedLoader(channelReader.GetChannels),
+		EventLoader:     dataloader.NewBatchedLoader(eventReader.GetEvents),
+		AttributeLoader: dataloader.NewBatchedLoader(attributeReader.GetAttributes),
 	}
 	return loaders
 }
@@ -37,6 +41,15 @@ func Middleware(loaders *Loaders, next http.Handler) http.Handler {
 	})
 }
 
+// Loaders cache what they load, so each request gets its own
+func GinMiddleware(tx *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := context.WithValue(c.Request.Context(), loadersKey, NewLoaders(tx))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
 func For(ctx context.Context) *Loaders {
 	return ctx.Value(loadersKey).(*Loaders)
 }
@@ -0,0 +1,264 @@
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kaigoh/loggo/models"
+)
+
+// Facility names, by number
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// Messages without a priority are treated as user.notice
+const defaultPriority = 13
+
+// The value RFC 5424 uses for a field which isn't there
+const nilValue = "-"
+
+// Message is a parsed syslog message, in either format. Fields which weren't sent are left blank.
+type Message struct {
+	Facility       int
+	Severity       int
+	Timestamp      *time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData string
+	Message        string
+}
+
+// The facility's name, or its number if it doesn't have one
+func (m *Message) FacilityName() string {
+	if m.Facility >= 0 && m.Facility < len(facilities) {
+		return facilities[m.Facility]
+	}
+	return strconv.Itoa(m.Facility)
+}
+
+// The event level for the message's severity...
+//
+// Emergency, alert and critical are all fatal, notice is info.
+func (m *Message) Level() models.EventLevel {
+	switch m.Severity {
+	case 0, 1, 2:
+		return models.EventLevelFatal
+	case 3:
+		return models.EventLevelError
+	case 4:
+		return models.EventLevelWarning
+	case 7:
+		return models.EventLevelDebug
+	}
+	return models.EventLevelInfo
+}
+
+// Where the message came from, host/app when there are both
+func (m *Message) Source() string {
+	var parts []string
+	if len(m.Hostname) > 0 {
+		parts = append(parts, m.Hostname)
+	}
+	if len(m.AppName) > 0 {
+		parts = append(parts, m.AppName)
+	}
+	if len(parts) == 0 {
+		return "syslog"
+	}
+	return strings.Join(parts, "/")
+}
+
+// The new event for the message
+func (m *Message) NewEvent() *models.NewEvent {
+	n := &models.NewEvent{
+		Source:  m.Source(),
+		Level:   m.Level(),
+		Message: m.Message,
+	}
+	if m.Timestamp != nil {
+		ts := m.Timestamp.Format(time.RFC3339Nano)
+		n.Timestamp = &ts
+	}
+	if len(m.MsgID) > 0 {
+		title := m.MsgID
+		n.Title = &title
+	}
+	return n
+}
+
+// Parse a message, either RFC 5424 or the older BSD format from RFC 3164...
+//
+// RFC 3164 timestamps have no year or timezone, so they are taken to be in loc and within the last year.
+// Anything after the priority which doesn't look like either format is kept as the message, as RFC 3164
+// asks, so only a malformed priority is an error.
+func Parse(data []byte, loc *time.Location) (*Message, error) {
+	data = bytes.TrimRight(data, "\r\n\x00")
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty syslog message")
+	}
+	if !utf8.Valid(data) {
+		data = bytes.ToValidUTF8(data, []byte("\ufffd"))
+	}
+
+	m := &Message{}
+	pri, rest, err := parsePriority(string(data))
+	if err != nil {
+		return nil, err
+	}
+	m.Facility = pri / 8
+	m.Severity = pri % 8
+
+	// RFC 5424 messages have a version straight after the priority...
+	if strings.HasPrefix(rest, "1 ") {
+		if err := m.parse5424(rest[2:]); err == nil {
+			return m, nil
+		}
+		*m = Message{Facility: pri / 8, Severity: pri % 8}
+	}
+	m.parse3164(rest, loc)
+	return m, nil
+}
+
+func parsePriority(s string) (pri int, rest string, err error) {
+	if len(s) == 0 || s[0] != '<' {
+		return defaultPriority, s, nil
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 2 || end > 4 {
+		return 0, "", fmt.Errorf("invalid syslog priority")
+	}
+	pri, err = strconv.Atoi(s[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, "", fmt.Errorf("invalid syslog priority '%s'", s[1:end])
+	}
+	return pri, s[end+1:], nil
+}
+
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func (m *Message) parse5424(s string) error {
+	var fields [5]string
+	for i := range fields {
+		sp := strings.IndexByte(s, ' ')
+		if sp < 1 {
+			return fmt.Errorf("truncated RFC 5424 header")
+		}
+		fields[i] = s[:sp]
+		s = s[sp+1:]
+	}
+	if fields[0] != nilValue {
+		ts, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid RFC 5424 timestamp: %w", err)
+		}
+		m.Timestamp = &ts
+	}
+	m.Hostname = optional(fields[1])
+	m.AppName = optional(fields[2])
+	m.ProcID = optional(fields[3])
+	m.MsgID = optional(fields[4])
+
+	sd, msg, err := splitStructuredData(s)
+	if err != nil {
+		return err
+	}
+	m.StructuredData = optional(sd)
+	m.Message = strings.TrimPrefix(msg, "\ufeff")
+	return nil
+}
+
+// Split the structured data from the message after it, which means finding the end of the last element
+// without being fooled by escaped brackets in parameter values
+func splitStructuredData(s string) (sd string, msg string, err error) {
+	if strings.HasPrefix(s, nilValue) {
+		return nilValue, strings.TrimPrefix(s[1:], " "), nil
+	}
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		quoted := false
+	element:
+		for i++; ; i++ {
+			if i >= len(s) {
+				return "", "", fmt.Errorf("unterminated RFC 5424 structured data")
+			}
+			switch {
+			case s[i] == '\\' && quoted:
+				i++
+			case s[i] == '"':
+				quoted = !quoted
+			case s[i] == ']' && !quoted:
+				break element
+			}
+		}
+		i++
+	}
+	if i == 0 {
+		return "", "", fmt.Errorf("missing RFC 5424 structured data")
+	}
+	return s[:i], strings.TrimPrefix(s[i:], " "), nil
+}
+
+func optional(field string) string {
+	if field == nilValue {
+		return ""
+	}
+	return field
+}
+
+// [TIMESTAMP HOSTNAME] [TAG[PID]:] MSG, with every part but the message optional in practice
+func (m *Message) parse3164(s string, loc *time.Location) {
+	if ts, rest, ok := parse3164Timestamp(s, loc); ok {
+		m.Timestamp = &ts
+		s = rest
+
+		// The hostname comes next, unless the sender left it out and this is the tag...
+		if sp := strings.IndexByte(s, ' '); sp > 0 && !isTag(s[:sp]) {
+			m.Hostname = s[:sp]
+			s = s[sp+1:]
+		}
+	}
+
+	if sp := strings.IndexByte(s, ' '); sp > 0 && isTag(s[:sp]) {
+		tag := strings.TrimSuffix(s[:sp], ":")
+		if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+			m.ProcID = tag[open+1 : len(tag)-1]
+			tag = tag[:open]
+		}
+		m.AppName = tag
+		s = s[sp+1:]
+	}
+	m.Message = s
+}
+
+// Tags are a program name, maybe with a process ID, followed by a colon
+func isTag(s string) bool {
+	return len(s) > 1 && len(s) <= 48 && (strings.HasSuffix(s, ":") || strings.HasSuffix(s, "]"))
+}
+
+// Mmm dd hh:mm:ss, with the day padded with a space
+func parse3164Timestamp(s string, loc *time.Location) (time.Time, string, bool) {
+	const layout = "Jan _2 15:04:05"
+	if len(s) < len(layout)+1 || s[len(layout)] != ' ' {
+		return time.Time{}, s, false
+	}
+	ts, err := time.ParseInLocation(layout, s[:len(layout)], loc)
+	if err != nil {
+		return time.Time{}, s, false
+	}
+
+	// There's no year, so assume it's from the last twelve months (a little slack for clock drift)...
+	now := time.Now().In(loc)
+	ts = ts.AddDate(now.Year(), 0, 0)
+	if ts.After(now.AddDate(0, 0, 7)) {
+		ts = ts.AddDate(-1, 0, 0)
+	}
+	return ts, s[len(layout)+1:], true
+}
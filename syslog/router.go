@@ -0,0 +1,94 @@
+package syslog
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/kaigoh/loggo/configuration"
+)
+
+type route struct {
+	channel  string
+	facility int
+	hostname string
+	appName  string
+}
+
+func (r *route) matches(m *Message) bool {
+	if r.facility >= 0 && r.facility != m.Facility {
+		return false
+	}
+	if !matchPattern(r.hostname, m.Hostname) {
+		return false
+	}
+	return matchPattern(r.appName, m.AppName)
+}
+
+func matchPattern(pattern string, value string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return ok
+}
+
+// Router picks the channel for a message from the configured routes, first match wins
+type Router struct {
+	Default string
+	routes  []*route
+}
+
+func NewRouter(config *configuration.Config) (*Router, error) {
+	r := &Router{Default: strings.TrimSpace(config.Syslog.DefaultChannel)}
+	for i, rc := range config.Syslog.Routes {
+		if len(strings.TrimSpace(rc.Channel)) == 0 {
+			return nil, fmt.Errorf("syslog route %d has no channel", i+1)
+		}
+		facility, err := parseFacility(rc.Facility)
+		if err != nil {
+			return nil, fmt.Errorf("syslog route %d: %w", i+1, err)
+		}
+		for _, pattern := range []string{rc.Hostname, rc.AppName} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("syslog route %d has an invalid pattern '%s'", i+1, pattern)
+			}
+		}
+		r.routes = append(r.routes, &route{
+			channel:  strings.TrimSpace(rc.Channel),
+			facility: facility,
+			hostname: rc.Hostname,
+			appName:  rc.AppName,
+		})
+	}
+	return r, nil
+}
+
+// The name of the channel for the message, false if it doesn't match a route and there's no default
+func (r *Router) Channel(m *Message) (string, bool) {
+	for _, rt := range r.routes {
+		if rt.matches(m) {
+			return rt.channel, true
+		}
+	}
+	return r.Default, len(r.Default) > 0
+}
+
+// A facility name or number, -1 for any
+func parseFacility(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) == 0 {
+		return -1, nil
+	}
+	for i, name := range facilities {
+		if name == s {
+			return i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 23 {
+		return 0, fmt.Errorf("unknown syslog facility '%s'", s)
+	}
+	return n, nil
+}
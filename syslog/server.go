@@ -0,0 +1,309 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kaigoh/loggo/broker"
+	"github.com/kaigoh/loggo/configuration"
+	"github.com/kaigoh/loggo/models"
+	"github.com/kaigoh/loggo/telemetry"
+)
+
+// TCP connections which send nothing for this long are closed
+const idleTimeout = 10 * time.Minute
+
+// Handler saves an event for a message routed to a channel
+type Handler func(channel string, event *models.NewEvent) error
+
+// Server receives syslog messages over UDP, TCP and TLS...
+//
+// UDP messages are one per datagram. TCP and TLS streams can use octet counting ("<length> <message>",
+// which RFC 5425 requires for TLS) or be newline delimited, and each message is checked for which one
+// it uses so senders can mix them.
+type Server struct {
+	UDPAddress     string
+	TCPAddress     string
+	TLSAddress     string
+	TLSConfig      *tls.Config
+	MaxMessageSize int
+	Router         *Router
+	Handler        Handler
+	// Where RFC 3164 timestamps, which have no timezone, are taken to be
+	Location *time.Location
+
+	mu        sync.Mutex
+	closed    bool
+	packet    net.PacketConn
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+func NewServer(config *configuration.Config, loc *time.Location, handler Handler) (*Server, error) {
+	router, err := NewRouter(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Syslog.MaxMessageSize < 480 {
+		return nil, fmt.Errorf("syslog max message size must be at least 480 bytes")
+	}
+	s := &Server{
+		MaxMessageSize: int(config.Syslog.MaxMessageSize),
+		Router:         router,
+		Handler:        handler,
+		Location:       loc,
+		conns:          map[net.Conn]struct{}{},
+	}
+	if config.Syslog.UDPPort > 0 {
+		s.UDPAddress = ":" + strconv.Itoa(int(config.Syslog.UDPPort))
+	}
+	if config.Syslog.TCPPort > 0 {
+		s.TCPAddress = ":" + strconv.Itoa(int(config.Syslog.TCPPort))
+	}
+	if tc := config.Syslog.TLS; tc.Enabled {
+		s.TLSAddress = ":" + strconv.Itoa(int(tc.Port))
+		s.TLSConfig, err = broker.TLSConfig(tc.CertFile, tc.KeyFile, tc.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Start listening, messages are received in the background until the server is closed
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.UDPAddress) > 0 {
+		pc, err := net.ListenPacket("udp", s.UDPAddress)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.packet = pc
+		log.Println("Listening for syslog on UDP " + s.UDPAddress)
+		s.wg.Add(1)
+		go s.serveUDP(pc)
+	}
+	if len(s.TCPAddress) > 0 {
+		l, err := net.Listen("tcp", s.TCPAddress)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+		log.Println("Listening for syslog on TCP " + s.TCPAddress)
+		s.wg.Add(1)
+		go s.serveTCP(l)
+	}
+	if len(s.TLSAddress) > 0 {
+		l, err := tls.Listen("tcp", s.TLSAddress, s.TLSConfig)
+		if err != nil {
+			s.closeListeners()
+			return err
+		}
+		s.listeners = append(s.listeners, l)
+		log.Println("Listening for syslog over TLS on " + s.TLSAddress)
+		s.wg.Add(1)
+		go s.serveTCP(l)
+	}
+	return nil
+}
+
+// Stop listening and close any open connections, waiting for messages being saved
+func (s *Server) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	s.closeListeners()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) closeListeners() {
+	if s.packet != nil {
+		s.packet.Close()
+	}
+	for _, l := range s.listeners {
+		l.Close()
+	}
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) {
+	defer s.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("Unable to read syslog datagram", err)
+			}
+			return
+		}
+		if n > s.MaxMessageSize {
+			n = s.MaxMessageSize
+		}
+		s.receive(buf[:n], addr)
+	}
+}
+
+func (s *Server) serveTCP(l net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("Unable to accept syslog connection", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReaderSize(conn, s.MaxMessageSize+16)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		frame, err := s.readFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Println("Closing syslog connection from "+conn.RemoteAddr().String(), err)
+			}
+			return
+		}
+		s.receive(frame, conn.RemoteAddr())
+	}
+}
+
+// Read the next message from a stream, octet counted if it starts with a length, otherwise up to a newline
+func (s *Server) readFrame(r *bufio.Reader) ([]byte, error) {
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case first[0] >= '1' && first[0] <= '9' && octetCounted(r):
+			header, err := r.ReadSlice(' ')
+			if err != nil {
+				return nil, fmt.Errorf("invalid octet count: %w", err)
+			}
+			length, err := strconv.Atoi(string(header[:len(header)-1]))
+			if err != nil || length > s.MaxMessageSize {
+				return nil, fmt.Errorf("invalid octet count '%s'", header[:len(header)-1])
+			}
+			frame := make([]byte, length)
+			if _, err := io.ReadFull(r, frame); err != nil {
+				return nil, err
+			}
+			return frame, nil
+		case first[0] == '\n' || first[0] == '\r' || first[0] == 0:
+			r.Discard(1)
+		default:
+			line, err := r.ReadSlice('\n')
+			if errors.Is(err, bufio.ErrBufferFull) {
+				return nil, fmt.Errorf("message longer than %d bytes", s.MaxMessageSize)
+			}
+			if err != nil && !(errors.Is(err, io.EOF) && len(line) > 0) {
+				return nil, err
+			}
+			return append([]byte{}, line...), nil
+		}
+	}
+}
+
+// Does the stream start with an octet count? Only digits followed by a space and the start of a message
+// (<PRI>) are taken as one, a newline framed message can start with a digit too.
+func octetCounted(r *bufio.Reader) bool {
+	for i := 1; ; i++ {
+		next, err := r.Peek(i + 1)
+		if err != nil {
+			return false
+		}
+		switch c := next[i]; {
+		case c >= '0' && c <= '9':
+			continue
+		case c == ' ':
+			next, err = r.Peek(i + 2)
+			return err == nil && next[i+1] == '<'
+		default:
+			return false
+		}
+	}
+}
+
+// Parse and route a message, then hand it on to be saved
+func (s *Server) receive(data []byte, from net.Addr) {
+	telemetry.PayloadSize.WithLabelValues(telemetry.TransportSyslog).Observe(float64(len(data)))
+
+	m, err := Parse(data, s.Location)
+	if err != nil {
+		telemetry.SyslogMessages.WithLabelValues(telemetry.ResultInvalid).Inc()
+		return
+	}
+	// RFC 3164 relays fill in the sender's address when there's no hostname, so do the same...
+	if len(m.Hostname) == 0 && from != nil {
+		if host, _, err := net.SplitHostPort(from.String()); err == nil {
+			m.Hostname = host
+		}
+	}
+
+	channel, ok := s.Router.Channel(m)
+	if !ok {
+		telemetry.SyslogMessages.WithLabelValues(telemetry.ResultUnrouted).Inc()
+		return
+	}
+	n := m.NewEvent()
+	if err := n.Validate(); err != nil {
+		telemetry.SyslogMessages.WithLabelValues(telemetry.ResultInvalid).Inc()
+		return
+	}
+	if err := s.Handler(channel, n); err != nil {
+		telemetry.SyslogMessages.WithLabelValues(telemetry.ResultFailed).Inc()
+		log.Println("Unable to save syslog message for channel '"+channel+"'", err)
+		return
+	}
+	telemetry.SyslogMessages.WithLabelValues(telemetry.ResultSaved).Inc()
+}
@@ -0,0 +1,172 @@
+package telemetry
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "loggo"
+
+// How an event reached us
+const (
+	TransportHTTPGet  = "http-get"
+	TransportHTTPPost = "http-post"
+	TransportLoki     = "loki"
+	// Elasticsearch bulk requests
+	TransportElasticsearch = "elasticsearch"
+	TransportMQTT          = "mqtt"
+	TransportOTLP          = "otlp"
+	TransportSyslog        = "syslog"
+	// Replayed from a spool record which didn't say how the event reached us
+	TransportSpool = "spool"
+)
+
+// Why an MQTT message wasn't saved
+const (
+	RejectUnknownChannel = "unknown_channel"
+	RejectInvalid        = "invalid"
+	RejectQuota          = "quota"
+	RejectQueueFull      = "queue_full"
+	RejectClosed         = "closed"
+	RejectError          = "error"
+)
+
+var (
+	EventsIngested = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_ingested_total",
+		Help:      "Events stored and published, by channel, level and transport.",
+	}, []string{"channel", "level", "transport"})
+
+	MQTTRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_rejected_total",
+		Help:      "MQTT messages which were acknowledged but not saved or passed on, by reason.",
+	}, []string{"reason"})
+
+	DBWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_write_duration_seconds",
+		Help:      "Time taken to write a batch of events to the database.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	PayloadSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "payload_size_bytes",
+		Help:      "Size of incoming request bodies and MQTT payloads, by transport.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"transport"})
+
+	PurgeRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purge_runs_total",
+		Help:      "Retention purge runs, by result.",
+	}, []string{"result"})
+
+	PurgedRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purged_rows_total",
+		Help:      "Rows deleted by the retention purge, by table.",
+	}, []string{"table"})
+
+	IngestEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ingest_events_total",
+		Help:      "Events through the write pipeline, by result (written, spooled, failed or queue_full).",
+	}, []string{"result"})
+
+	QuotaEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_events_total",
+		Help:      "Events turned away or dropped to keep channels within their quotas, by channel and action.",
+	}, []string{"channel", "action"})
+
+	SpoolEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "spool_events_total",
+		Help:      "Events written to the spool, replayed from it or set aside as unreplayable, by result.",
+	}, []string{"result"})
+
+	SyslogMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "syslog_messages_total",
+		Help:      "Syslog messages received, by result (saved, invalid, unrouted or failed).",
+	}, []string{"result"})
+
+	NtfyNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ntfy_notifications_total",
+		Help:      "Notifications for ntfy, by result (sent, failed or dropped when the queue is full).",
+	}, []string{"result"})
+)
+
+// Results for the counters above
+const (
+	ResultWritten   = "written"
+	ResultSpooled   = "spooled"
+	ResultFailed    = "failed"
+	ResultQueueFull = "queue_full"
+	ResultReplayed  = "replayed"
+	ResultRejected  = "rejected"
+	ResultDropped   = "dropped"
+	ResultSaved     = "saved"
+	ResultInvalid   = "invalid"
+	ResultUnrouted  = "unrouted"
+	ResultSent      = "sent"
+)
+
+// Gauges which are read when scraped rather than kept up to date, they read 0 until they are Set
+var (
+	MQTTClients          = newScrapedGauge("mqtt_clients_connected", "MQTT clients currently connected.")
+	GraphQLSubscriptions = newScrapedGauge("graphql_subscriptions_active", "GraphQL event subscriptions currently open.")
+	IngestQueued         = newScrapedGauge("ingest_queued_events", "Events queued or being written by the write pipeline.")
+	SpoolPending         = newScrapedGauge("spool_pending_events", "Events in the spool waiting to be replayed.")
+	NtfyQueued           = newScrapedGauge("ntfy_queued_notifications", "Notifications waiting to be sent to ntfy.")
+)
+
+// A gauge registered once which reads from a function that can be swapped out, so the function can be set
+// again each time the server starts
+type ScrapedGauge struct {
+	mu   sync.RWMutex
+	read func() float64
+}
+
+func newScrapedGauge(name string, help string) *ScrapedGauge {
+	g := &ScrapedGauge{}
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, g.value)
+	return g
+}
+
+func (g *ScrapedGauge) Set(read func() float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.read = read
+}
+
+func (g *ScrapedGauge) value() float64 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.read == nil {
+		return 0
+	}
+	return g.read()
+}
+
+// Time a database write
+func ObserveDBWrite(start time.Time) {
+	DBWriteDuration.Observe(time.Since(start).Seconds())
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}